@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -56,21 +59,25 @@ type activityReport struct {
 }
 
 type PGCR struct {
-	Period          time.Time       `json:"period" bson:"Period"`
-	ActivityDetails activityDetails `json:"activityDetails" bson:"ActivityDetails"`
-	Entries         []struct {
+	Period                          time.Time       `json:"period" bson:"Period"`
+	ActivityWasStartedFromBeginning *bool           `json:"activityWasStartedFromBeginning,omitempty" bson:"ActivityWasStartedFromBeginning,omitempty"`
+	ActivityDetails                 activityDetails `json:"activityDetails" bson:"ActivityDetails"`
+	Entries                         []struct {
 		Standing int `json:"standing" bson:"Standing"`
 		Score    struct {
 			Basic basicValue `json:"basic" bson:"Basic"`
 		} `json:"score" bson:"Score"`
 		Player struct {
-			DestinyUserInfo   Player `json:"destinyUserInfo" bson:"DestinyUserInfo"`
-			CharacterClass    string `json:"characterClass" bson:"CharacterClass"`
-			ClassHash         int    `json:"classHash" bson:"ClassHash"`
-			RaceHash          int64  `json:"raceHash" bson:"RaceHash"`
-			GenderHash        int64  `json:"genderHash" bson:"GenderHash"`
-			CharacterLevel    int    `json:"characterLevel" bson:"CharacterLevel"`
-			LightLevel        int    `json:"lightLevel" bson:"LightLevel"`
+			DestinyUserInfo   Player        `json:"destinyUserInfo" bson:"DestinyUserInfo"`
+			CharacterClass    string        `json:"characterClass" bson:"CharacterClass"`
+			ClassHash         int64         `json:"classHash" bson:"ClassHash"`
+			RaceHash          int64         `json:"raceHash" bson:"RaceHash"`
+			GenderHash        int64         `json:"genderHash" bson:"GenderHash"`
+			Class             DestinyClass  `json:"-" bson:"Class"`
+			Race              DestinyRace   `json:"-" bson:"Race"`
+			Gender            DestinyGender `json:"-" bson:"Gender"`
+			CharacterLevel    int           `json:"characterLevel" bson:"CharacterLevel"`
+			LightLevel        int           `json:"lightLevel" bson:"LightLevel"`
 			BungieNetUserInfo struct {
 				IconPath       string `json:"iconPath" bson:"IconPath"`
 				MembershipType int    `json:"membershipType" bson:"MembershipType"`
@@ -132,7 +139,10 @@ type PGCR struct {
 		} `json:"values" bson:"Values"`
 		Extended struct {
 			Weapons []struct {
-				ReferenceID int64 `json:"referenceId" bson:"ReferenceId"`
+				ReferenceID int64  `json:"referenceId" bson:"ReferenceId"`
+				Name        string `json:"name,omitempty" bson:"Name,omitempty"`
+				ItemType    string `json:"itemType,omitempty" bson:"ItemType,omitempty"`
+				TierType    string `json:"tierType,omitempty" bson:"TierType,omitempty"`
 				Values      struct {
 					UniqueWeaponKills struct {
 						Basic basicValue `json:"basic" bson:"Basic"`
@@ -168,12 +178,12 @@ type PGCR struct {
 }
 
 type activityDetails struct {
-	ReferenceID          int64  `json:"referenceId" bson:"ReferenceID"`
-	DirectorActivityHash int64  `json:"directorActivityHash" bson:"DirectorActivityHash"`
-	InstanceID           string `json:"instanceId" bson:"InstanceID"`
-	Mode                 int    `json:"mode" bson:"Mode"`
-	Modes                []int  `json:"modes" bson:"Modes"`
-	IsPrivate            bool   `json:"isPrivate" bson:"IsPrivate"`
+	ReferenceID          int64                     `json:"referenceId" bson:"ReferenceID"`
+	DirectorActivityHash int64                     `json:"directorActivityHash" bson:"DirectorActivityHash"`
+	InstanceID           string                    `json:"instanceId" bson:"InstanceID"`
+	Mode                 DestinyActivityModeType   `json:"mode" bson:"Mode"`
+	Modes                []DestinyActivityModeType `json:"modes" bson:"Modes"`
+	IsPrivate            bool                      `json:"isPrivate" bson:"IsPrivate"`
 }
 
 type statValue struct {
@@ -189,6 +199,20 @@ type basicValue struct {
 	DisplayValue string  `json:"displayValue" bson:"DisplayValue"`
 }
 
+// FireteamID returns the fireteam ID of an entry, or an empty string when the entry has no fireteam. The IDs are 64 bit
+// and lose precision as a float, so the display value is used, which holds the full ID.
+func FireteamID(value basicValue) string {
+	id := value.DisplayValue
+	if id == "" && value.Value != 0 {
+		id = strconv.FormatFloat(value.Value, 'f', -1, 64)
+	}
+	if id == "0" {
+		return ""
+	}
+
+	return id
+}
+
 type hashedActivityDetails struct {
 	Response        HashedActivityDetails `json:"Response"`
 	ErrorCode       int                   `json:"ErrorCode"`
@@ -209,11 +233,11 @@ type HashedActivityDetails struct {
 	ReleaseIcon           string        `json:"releaseIcon" bson:"ReleaseIcon,omitempty"`
 	ReleaseTime           int           `json:"releaseTime" bson:"ReleaseTime,omitempty"`
 	ActivityLevel         int           `json:"activityLevel" bson:"ActivityLevel,omitempty"`
-	CompletionUnlockHash  int           `json:"completionUnlockHash" bson:"CompletionUnlockHash,omitempty"`
+	CompletionUnlockHash  int64         `json:"completionUnlockHash" bson:"CompletionUnlockHash,omitempty"`
 	ActivityLightLevel    int           `json:"activityLightLevel" bson:"ActivityLightLevel,omitempty"`
 	DestinationHash       int64         `json:"destinationHash" bson:"DestinationHash,omitempty"`
 	PlaceHash             int64         `json:"placeHash" bson:"PlaceHash,omitempty"`
-	ActivityTypeHash      int           `json:"activityTypeHash" bson:"ActivityTypeHash,omitempty"`
+	ActivityTypeHash      int64         `json:"activityTypeHash" bson:"ActivityTypeHash,omitempty"`
 	Tier                  int           `json:"tier" bson:"Tier,omitempty"`
 	PgcrImage             string        `json:"pgcrImage" bson:"PGCRImage,omitempty"`
 	Rewards               []interface{} `json:"rewards" bson:"Rewards,omitempty"`
@@ -234,51 +258,60 @@ type HashedActivityDetails struct {
 		MaxPlayers           int  `json:"maxPlayers" bson:"MaxPlayers,omitempty"`
 		RequiresGuardianOath bool `json:"requiresGuardianOath" bson:"RequiresGuardianOath,omitempty"`
 	} `json:"matchmaking" bson:"Matchmaking,omitempty"`
-	DirectActivityModeHash int   `json:"directActivityModeHash" bson:"DirectActivityModeHash,omitempty"`
-	DirectActivityModeType int   `json:"directActivityModeType" bson:"DirectActivityModeType,omitempty"`
-	ActivityModeHashes     []int `json:"activityModeHashes" bson:"ActivityModeHashes,omitempty"`
-	ActivityModeTypes      []int `json:"activityModeTypes" bson:"ActivityModeTypes,omitempty"`
-	IsPvP                  bool  `json:"isPvP" bson:"IsPvP,omitempty"`
-	Hash                   int64 `json:"hash" bson:"Hash,omitempty"`
-	Index                  int   `json:"index" bson:"Index,omitempty"`
-	Redacted               bool  `json:"redacted" bson:"Redacted,omitempty"`
-	Blacklisted            bool  `json:"blacklisted" bson:"Blacklisted,omitempty"`
+	DirectActivityModeHash int64   `json:"directActivityModeHash" bson:"DirectActivityModeHash,omitempty"`
+	DirectActivityModeType int     `json:"directActivityModeType" bson:"DirectActivityModeType,omitempty"`
+	ActivityModeHashes     []int64 `json:"activityModeHashes" bson:"ActivityModeHashes,omitempty"`
+	ActivityModeTypes      []int   `json:"activityModeTypes" bson:"ActivityModeTypes,omitempty"`
+	IsPvP                  bool    `json:"isPvP" bson:"IsPvP,omitempty"`
+	Hash                   int64   `json:"hash" bson:"Hash,omitempty"`
+	Index                  int     `json:"index" bson:"Index,omitempty"`
+	Redacted               bool    `json:"redacted" bson:"Redacted,omitempty"`
+	Blacklisted            bool    `json:"blacklisted" bson:"Blacklisted,omitempty"`
 }
 
-func GetActivities(memberID string, characterID string, lastInstanceID string) []PGCR {
+// GetActivities returns the reports of all activities of a character played after the given instance, limited to a
+// single activity mode unless mode is 0. An error is returned when any page of the history or any report could not be
+// retrieved, so that a partial history is never mistaken for a complete one.
+func GetActivities(membershipType int, memberID string, characterID string, mode int, lastInstanceID string) ([]PGCR, error) {
 	page := 0
 	activities := []string{}
 	retval := []PGCR{}
 	consumed := false
 
 	for !consumed {
-		url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Account/%s/Character/%s/Stats/Activities?count=%d&page=%d", config.MembershipType, url.QueryEscape(memberID), url.QueryEscape(characterID), config.ActivityBatchSize, page)
+		url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Account/%s/Character/%s/Stats/Activities?count=%d&page=%d", MembershipTypeFor(membershipType), url.QueryEscape(memberID), url.QueryEscape(characterID), config.ActivityBatchSize, page)
+		if mode != 0 {
+			url = fmt.Sprintf("%s&mode=%d", url, mode)
+		}
 		req, err := http.NewRequest("GET", url, nil)
 		req.Header.Add("x-api-key", config.APIKey)
 		if err != nil {
 			log.Fatal("NewRequest: ", err)
-			return nil
+			return nil, err
 		}
 
 		client := &http.Client{}
 		resp, err := client.Do(req)
 		if err != nil {
 			log.Fatal("Do: ", err)
-			return nil
+			return nil, err
 		}
 		defer resp.Body.Close()
 
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			log.Fatal("Body: ", err)
-			return nil
+			return nil, err
 		}
 
 		// Fill the record with the data from the JSON
 		var record characterActivities
 		if err := json.Unmarshal([]byte(string(body)), &record); err != nil {
 			log.Printf("Unmarshal error: %v", err)
-			return nil
+			return nil, err
+		}
+		if record.ErrorCode != 1 {
+			return nil, errors.New(record.Message)
 		}
 
 		if len(record.Response.Activities) == 0 {
@@ -303,12 +336,12 @@ func GetActivities(memberID string, characterID string, lastInstanceID string) [
 		pgcr, err := GetPGCR(activity)
 		if err != nil {
 			log.Printf("Error getting PGCR: %v", err)
-			return nil
+			return nil, err
 		}
 		retval = append(retval, pgcr)
 	}
 
-	return retval
+	return retval, nil
 }
 
 func GetPGCR(InstanceID string) (PGCR, error) {
@@ -340,6 +373,49 @@ func GetPGCR(InstanceID string) (PGCR, error) {
 		log.Printf("Unmarshal error: %v", err)
 		return PGCR{}, err
 	}
+	if record.ErrorCode != 1 {
+		return PGCR{}, errors.New(record.Message)
+	}
+
+	ResolveWeapons(&record.Response)
+	ResolveCharacterTypes(&record.Response)
 
 	return record.Response, nil
 }
+
+// ResolveCharacterTypes sets the class, race and gender of every entry from their hashes in the manifest. Without a
+// manifest the class is taken from the class name of the entry, and whatever cannot be resolved is stored as unknown
+// until ResolveStoredCharacterTypes is run with a manifest.
+func ResolveCharacterTypes(pgcr *PGCR) {
+	for i := range pgcr.Entries {
+		player := &pgcr.Entries[i].Player
+		player.Class = ClassForHash(player.ClassHash)
+		if player.Class == ClassUnknown {
+			for class, name := range classNames {
+				if strings.EqualFold(name, player.CharacterClass) {
+					player.Class = class
+				}
+			}
+		}
+		player.Race = RaceForHash(player.RaceHash)
+		player.Gender = GenderForHash(player.GenderHash)
+	}
+}
+
+// ResolveWeapons attaches the name, item type and tier from the manifest to the extended weapon data of every entry
+func ResolveWeapons(pgcr *PGCR) {
+	if definitions == nil {
+		return
+	}
+
+	for i := range pgcr.Entries {
+		for j := range pgcr.Entries[i].Extended.Weapons {
+			weapon := &pgcr.Entries[i].Extended.Weapons[j]
+			if item, ok := definitions.Items[weapon.ReferenceID]; ok {
+				weapon.Name = item.DisplayProperties.Name
+				weapon.ItemType = item.ItemTypeDisplayName
+				weapon.TierType = item.Inventory.TierTypeName
+			}
+		}
+	}
+}
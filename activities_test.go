@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestFireteamID(t *testing.T) {
+	tests := []struct {
+		name  string
+		value basicValue
+		want  string
+	}{
+		{name: "display value", value: basicValue{Value: 2305843009260000000, DisplayValue: "2305843009260187145"}, want: "2305843009260187145"},
+		{name: "value only", value: basicValue{Value: 12345}, want: "12345"},
+		{name: "no fireteam", value: basicValue{}, want: ""},
+		{name: "zero display value", value: basicValue{DisplayValue: "0"}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FireteamID(test.value); got != test.want {
+				t.Errorf("FireteamID() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DestinyActivityModeType is the mode of an activity, matching the modeType of the manifest activity mode definitions
+type DestinyActivityModeType int
+
+const (
+	ModeNone                  DestinyActivityModeType = 0
+	ModeStory                 DestinyActivityModeType = 2
+	ModeStrike                DestinyActivityModeType = 3
+	ModeRaid                  DestinyActivityModeType = 4
+	ModeAllPvP                DestinyActivityModeType = 5
+	ModePatrol                DestinyActivityModeType = 6
+	ModeAllPvE                DestinyActivityModeType = 7
+	ModeControl               DestinyActivityModeType = 10
+	ModeClash                 DestinyActivityModeType = 12
+	ModeNightfall             DestinyActivityModeType = 16
+	ModeHeroicNightfall       DestinyActivityModeType = 17
+	ModeAllStrikes            DestinyActivityModeType = 18
+	ModeIronBanner            DestinyActivityModeType = 19
+	ModeAllMayhem             DestinyActivityModeType = 25
+	ModeSupremacy             DestinyActivityModeType = 31
+	ModePrivateMatchesAll     DestinyActivityModeType = 32
+	ModeSurvival              DestinyActivityModeType = 37
+	ModeCountdown             DestinyActivityModeType = 38
+	ModeTrialsOfTheNine       DestinyActivityModeType = 39
+	ModeSocial                DestinyActivityModeType = 40
+	ModeScoredNightfall       DestinyActivityModeType = 46
+	ModeScoredHeroicNightfall DestinyActivityModeType = 47
+	ModeRumble                DestinyActivityModeType = 48
+	ModeAllDoubles            DestinyActivityModeType = 49
+	ModeHeroicAdventure       DestinyActivityModeType = 58
+	ModeShowdown              DestinyActivityModeType = 59
+	ModeLockdown              DestinyActivityModeType = 60
+	ModeScorched              DestinyActivityModeType = 61
+	ModeGambit                DestinyActivityModeType = 63
+	ModeAllPvECompetitive     DestinyActivityModeType = 64
+	ModeBreakthrough          DestinyActivityModeType = 65
+	ModeBlackArmoryRun        DestinyActivityModeType = 66
+	ModeSalvage               DestinyActivityModeType = 67
+	ModePvPCompetitive        DestinyActivityModeType = 69
+	ModePvPQuickplay          DestinyActivityModeType = 70
+	ModeGambitPrime           DestinyActivityModeType = 75
+	ModeReckoning             DestinyActivityModeType = 76
+	ModeMenagerie             DestinyActivityModeType = 77
+	ModeVexOffensive          DestinyActivityModeType = 78
+	ModeNightmareHunt         DestinyActivityModeType = 79
+	ModeElimination           DestinyActivityModeType = 80
+	ModeMomentum              DestinyActivityModeType = 81
+	ModeDungeon               DestinyActivityModeType = 82
+	ModeSundial               DestinyActivityModeType = 83
+	ModeTrialsOfOsiris        DestinyActivityModeType = 84
+)
+
+// Names used when no manifest has been loaded
+var modeNames = map[DestinyActivityModeType]string{
+	ModeNone:                  "None",
+	ModeStory:                 "Story",
+	ModeStrike:                "Strike",
+	ModeRaid:                  "Raid",
+	ModeAllPvP:                "Crucible",
+	ModePatrol:                "Patrol",
+	ModeAllPvE:                "PvE",
+	ModeControl:               "Control",
+	ModeClash:                 "Clash",
+	ModeNightfall:             "Nightfall",
+	ModeHeroicNightfall:       "Heroic Nightfall",
+	ModeAllStrikes:            "Strikes",
+	ModeIronBanner:            "Iron Banner",
+	ModeAllMayhem:             "Mayhem",
+	ModeSupremacy:             "Supremacy",
+	ModePrivateMatchesAll:     "Private Matches",
+	ModeSurvival:              "Survival",
+	ModeCountdown:             "Countdown",
+	ModeTrialsOfTheNine:       "Trials of the Nine",
+	ModeSocial:                "Social",
+	ModeScoredNightfall:       "Scored Nightfall",
+	ModeScoredHeroicNightfall: "Scored Heroic Nightfall",
+	ModeRumble:                "Rumble",
+	ModeAllDoubles:            "Doubles",
+	ModeHeroicAdventure:       "Heroic Adventure",
+	ModeShowdown:              "Showdown",
+	ModeLockdown:              "Lockdown",
+	ModeScorched:              "Scorched",
+	ModeGambit:                "Gambit",
+	ModeAllPvECompetitive:     "PvE Competitive",
+	ModeBreakthrough:          "Breakthrough",
+	ModeBlackArmoryRun:        "Forge",
+	ModeSalvage:               "Salvage",
+	ModePvPCompetitive:        "Competitive",
+	ModePvPQuickplay:          "Quickplay",
+	ModeGambitPrime:           "Gambit Prime",
+	ModeReckoning:             "The Reckoning",
+	ModeMenagerie:             "The Menagerie",
+	ModeVexOffensive:          "Vex Offensive",
+	ModeNightmareHunt:         "Nightmare Hunt",
+	ModeElimination:           "Elimination",
+	ModeMomentum:              "Momentum Control",
+	ModeDungeon:               "Dungeon",
+	ModeSundial:               "The Sundial",
+	ModeTrialsOfOsiris:        "Trials of Osiris",
+}
+
+// activityCategories maps each category to the modes it is rooted at. Activities carry their parent modes as well, and
+// with a manifest loaded every mode descending from these roots is included too.
+var activityCategories = map[string][]DestinyActivityModeType{
+	"PvE":       {ModeAllPvE},
+	"PvP":       {ModeAllPvP},
+	"Raid":      {ModeRaid},
+	"Dungeon":   {ModeDungeon},
+	"Gambit":    {ModeGambit, ModeGambitPrime, ModeReckoning},
+	"Strike":    {ModeStrike, ModeAllStrikes},
+	"Nightfall": {ModeNightfall, ModeHeroicNightfall, ModeScoredNightfall, ModeScoredHeroicNightfall},
+	"Trials":    {ModeTrialsOfTheNine, ModeTrialsOfOsiris},
+}
+
+// String returns the localized mode name from the manifest
+func (mode DestinyActivityModeType) String() string {
+	if definitions != nil {
+		for _, definition := range definitions.ActivityModes {
+			if DestinyActivityModeType(definition.ModeType) == mode && definition.DisplayProperties.Name != "" {
+				return definition.DisplayProperties.Name
+			}
+		}
+	}
+
+	if name, ok := modeNames[mode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Mode %d", int(mode))
+}
+
+// CategoryModes returns all modes in the given categories. Besides the category names, the name or number of a single
+// mode can be given. No categories means no filter, for which an empty list is returned.
+func CategoryModes(categories []string) ([]DestinyActivityModeType, error) {
+	modes := []DestinyActivityModeType{}
+	for _, category := range categories {
+		roots, ok := LookupCategory(category)
+		if !ok {
+			mode, ok := LookupMode(category)
+			if !ok {
+				return nil, fmt.Errorf("unknown activity category %s", category)
+			}
+			roots = []DestinyActivityModeType{mode}
+		}
+
+		for _, mode := range DescendantModes(roots) {
+			if !ContainsMode(modes, mode) {
+				modes = append(modes, mode)
+			}
+		}
+	}
+
+	return modes, nil
+}
+
+// ModeCategories returns the names of the categories an activity with the given modes belongs to
+func ModeCategories(modes []DestinyActivityModeType) []string {
+	categories := []string{}
+	for category, roots := range activityCategories {
+		for _, mode := range DescendantModes(roots) {
+			if ContainsMode(modes, mode) {
+				categories = append(categories, category)
+				break
+			}
+		}
+	}
+	sort.Strings(categories)
+
+	return categories
+}
+
+// ActivityModes returns the modes of an activity from its manifest definition, or none without a manifest
+func ActivityModes(hash int64) []DestinyActivityModeType {
+	modes := []DestinyActivityModeType{}
+	if definitions == nil {
+		return modes
+	}
+
+	if definition, ok := definitions.Activities[hash]; ok {
+		for _, mode := range definition.ActivityModeTypes {
+			modes = append(modes, DestinyActivityModeType(mode))
+		}
+		if definition.DirectActivityModeType != 0 && !ContainsMode(modes, DestinyActivityModeType(definition.DirectActivityModeType)) {
+			modes = append(modes, DestinyActivityModeType(definition.DirectActivityModeType))
+		}
+	}
+
+	return modes
+}
+
+// SharesMode reports whether any of the modes is in the list
+func SharesMode(modes []DestinyActivityModeType, list []DestinyActivityModeType) bool {
+	for _, mode := range modes {
+		if ContainsMode(list, mode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LookupCategory finds a category by name, ignoring case
+func LookupCategory(name string) ([]DestinyActivityModeType, bool) {
+	for category, roots := range activityCategories {
+		if strings.EqualFold(category, name) {
+			return roots, true
+		}
+	}
+
+	return nil, false
+}
+
+// LookupMode finds a mode by its number, its manifest name or its default name, ignoring case
+func LookupMode(name string) (DestinyActivityModeType, bool) {
+	if number, err := strconv.Atoi(name); err == nil {
+		return DestinyActivityModeType(number), true
+	}
+
+	if definitions != nil {
+		for _, definition := range definitions.ActivityModes {
+			if strings.EqualFold(definition.DisplayProperties.Name, name) || strings.EqualFold(definition.FriendlyName, name) {
+				return DestinyActivityModeType(definition.ModeType), true
+			}
+		}
+	}
+
+	for mode, modeName := range modeNames {
+		if strings.EqualFold(modeName, name) {
+			return mode, true
+		}
+	}
+
+	return ModeNone, false
+}
+
+// DescendantModes returns the given modes together with every mode that has one of them as an ancestor in the manifest
+func DescendantModes(roots []DestinyActivityModeType) []DestinyActivityModeType {
+	modes := append([]DestinyActivityModeType{}, roots...)
+	if definitions == nil {
+		return modes
+	}
+
+	for _, definition := range definitions.ActivityModes {
+		mode := DestinyActivityModeType(definition.ModeType)
+		if ContainsMode(modes, mode) {
+			continue
+		}
+		if HasAncestorMode(definition, roots, 0) {
+			modes = append(modes, mode)
+		}
+	}
+
+	return modes
+}
+
+// HasAncestorMode reports whether any of the parents of a mode definition, directly or further up, is one of the roots
+func HasAncestorMode(definition ActivityModeDefinition, roots []DestinyActivityModeType, depth int) bool {
+	// The mode hierarchy is shallow, the depth limit only guards against cycles
+	if depth > 10 {
+		return false
+	}
+
+	for _, parentHash := range definition.ParentHashes {
+		parent, ok := definitions.ActivityModes[parentHash]
+		if !ok {
+			continue
+		}
+		if ContainsMode(roots, DestinyActivityModeType(parent.ModeType)) || HasAncestorMode(parent, roots, depth+1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ContainsMode(baselist []DestinyActivityModeType, mode DestinyActivityModeType) bool {
+	for _, item := range baselist {
+		if item == mode {
+			return true
+		}
+	}
+
+	return false
+}
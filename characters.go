@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -39,9 +40,9 @@ type character struct {
 	RaceHash                 int64         `json:"raceHash"`
 	GenderHash               int64         `json:"genderHash"`
 	ClassHash                int64         `json:"classHash"`
-	RaceType                 int           `json:"raceType"`
-	ClassType                int           `json:"classType"`
-	GenderType               int           `json:"genderType"`
+	RaceType                 DestinyRace   `json:"raceType"`
+	ClassType                DestinyClass  `json:"classType"`
+	GenderType               DestinyGender `json:"genderType"`
 	EmblemPath               string        `json:"emblemPath"`
 	EmblemBackgroundPath     string        `json:"emblemBackgroundPath"`
 	EmblemHash               int64         `json:"emblemHash"`
@@ -69,44 +70,64 @@ type character struct {
 }
 
 type Character struct {
-	MembershipID          string    `json:"MembershipID" bson:"MembershipID"`
-	CharacterID           string    `json:"CharacterID" bson:"CharacterID"`
-	Race                  int       `json:"Race" bson:"Race"`
-	Gender                int       `json:"Gender" bson:"Gender"`
-	Class                 int       `json:"Class" bson:"Class"`
+	MembershipID          string                       `json:"MembershipID" bson:"MembershipID"`
+	MembershipType        int                          `json:"MembershipType" bson:"MembershipType"`
+	CharacterID           string                       `json:"CharacterID" bson:"CharacterID"`
+	Race                  DestinyRace                  `json:"Race" bson:"Race"`
+	Gender                DestinyGender                `json:"Gender" bson:"Gender"`
+	Class                 DestinyClass                 `json:"Class" bson:"Class"`
+	RaceHash              int64                        `json:"RaceHash" bson:"RaceHash,omitempty"`
+	GenderHash            int64                        `json:"GenderHash" bson:"GenderHash,omitempty"`
+	ClassHash             int64                        `json:"ClassHash" bson:"ClassHash,omitempty"`
+	LastRetrievedActivity string                       `json:"LastRetrievedActivity" bson:"LastRetrievedActivity"`
+	LastRetrievedDate     time.Time                    `json:"LastRetrievedDate" bson:"LastRetrievedDate"`
+	DateLastPlayed        time.Time                    `json:"DateLastPlayed" bson:"DateLastPlayed"`
+	Enabled               bool                         `json:"Enabled" bson:"Enabled"`
+	Deleted               bool                         `json:"Deleted" bson:"Deleted,omitempty"`
+	DateDeleted           time.Time                    `json:"DateDeleted" bson:"DateDeleted,omitempty"`
+	FinalCrawlDone        bool                         `json:"FinalCrawlDone" bson:"FinalCrawlDone,omitempty"`
+	ModeWatermarks        map[string]ActivityWatermark `json:"ModeWatermarks" bson:"ModeWatermarks,omitempty"`
+}
+
+// ActivityWatermark records the most recent activity retrieved for a character, either across all modes or for a
+// single activity mode
+type ActivityWatermark struct {
 	LastRetrievedActivity string    `json:"LastRetrievedActivity" bson:"LastRetrievedActivity"`
 	LastRetrievedDate     time.Time `json:"LastRetrievedDate" bson:"LastRetrievedDate"`
-	DateLastPlayed        time.Time `json:"DateLastPlayed" bson:"DateLastPlayed"`
-	Enabled               bool      `json:"Enabled" bson:"Enabled"`
 }
 
-func GetCharacters(memberID string) []Character {
-	url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Profile/%s?components=200", config.MembershipType, url.QueryEscape(memberID))
+func GetCharacters(membershipType int, memberID string) ([]Character, error) {
+	url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Profile/%s?components=200", MembershipTypeFor(membershipType), url.QueryEscape(memberID))
 	req, err := http.NewRequest("GET", url, nil)
 	req.Header.Add("x-api-key", config.APIKey)
 	if err != nil {
 		log.Fatal("NewRequest: ", err)
-		return nil
+		return nil, err
 	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Fatal("Do: ", err)
-		return nil
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal("Body: ", err)
-		return nil
+		return nil, err
 	}
 
 	// Fill the record with the data from the JSON
 	var record memberChars
 	if err := json.Unmarshal([]byte(string(body)), &record); err != nil {
 		log.Printf("Unmarshal error: %v", err)
+		return nil, err
+	}
+
+	if record.ErrorCode != 1 {
+		return nil, errors.New(record.Message)
 	}
 
 	characters := []Character{}
@@ -116,51 +137,132 @@ func GetCharacters(memberID string) []Character {
 			characters,
 			Character{
 				MembershipID:   memberID,
+				MembershipType: character.MembershipType,
 				CharacterID:    character.CharacterID,
 				Race:           character.RaceType,
 				Gender:         character.GenderType,
 				Class:          character.ClassType,
+				RaceHash:       character.RaceHash,
+				GenderHash:     character.GenderHash,
+				ClassHash:      character.ClassHash,
 				DateLastPlayed: character.DateLastPlayed,
 			},
 		)
 	}
 
-	return characters
+	return characters, nil
 }
 
-func Race(raceID int) string {
-	switch raceID {
-	case 0:
-		return "Human"
-	case 1:
-		return "Awoken"
-	case 2:
-		return "Exo"
+// DestinyClass is the class of a character, matching the classType of the manifest class definitions
+type DestinyClass int
+
+// DestinyRace is the race of a character, matching the raceType of the manifest race definitions
+type DestinyRace int
+
+// DestinyGender is the gender of a character, matching the genderType of the manifest gender definitions
+type DestinyGender int
+
+const (
+	ClassTitan DestinyClass = iota
+	ClassHunter
+	ClassWarlock
+	ClassUnknown
+)
+
+const (
+	RaceHuman DestinyRace = iota
+	RaceAwoken
+	RaceExo
+	RaceUnknown
+)
+
+const (
+	GenderMale DestinyGender = iota
+	GenderFemale
+	GenderUnknown
+)
+
+// Names used when no manifest has been loaded
+var (
+	classNames  = map[DestinyClass]string{ClassTitan: "Titan", ClassHunter: "Hunter", ClassWarlock: "Warlock"}
+	raceNames   = map[DestinyRace]string{RaceHuman: "Human", RaceAwoken: "Awoken", RaceExo: "Exo"}
+	genderNames = map[DestinyGender]string{GenderMale: "Male", GenderFemale: "Female"}
+)
+
+// String returns the localized class name from the manifest
+func (class DestinyClass) String() string {
+	if definitions != nil {
+		if hash, ok := definitions.ClassHashes[class]; ok {
+			return ClassName(hash)
+		}
+	}
+
+	if name, ok := classNames[class]; ok {
+		return name
+	}
+
+	return "Unknown Class"
+}
+
+// String returns the localized race name from the manifest
+func (race DestinyRace) String() string {
+	if definitions != nil {
+		if hash, ok := definitions.RaceHashes[race]; ok {
+			return RaceName(hash)
+		}
+	}
+
+	if name, ok := raceNames[race]; ok {
+		return name
 	}
 
 	return "Unknown Race"
 }
 
-func Gender(genderID int) string {
-	switch genderID {
-	case 0:
-		return "Male"
-	case 1:
-		return "Female"
+// String returns the localized gender name from the manifest
+func (gender DestinyGender) String() string {
+	if definitions != nil {
+		if hash, ok := definitions.GenderHashes[gender]; ok {
+			return GenderName(hash)
+		}
+	}
+
+	if name, ok := genderNames[gender]; ok {
+		return name
 	}
 
 	return "Genderless"
 }
 
-func Class(classID int) string {
-	switch classID {
-	case 0:
-		return "Titan"
-	case 1:
-		return "Hunter"
-	case 2:
-		return "Warlock"
+// ClassForHash returns the class for a class hash, as stored in PGCR entries
+func ClassForHash(hash int64) DestinyClass {
+	if definitions != nil {
+		if definition, ok := definitions.Classes[hash]; ok {
+			return DestinyClass(definition.ClassType)
+		}
 	}
 
-	return "Unknown Class"
+	return ClassUnknown
+}
+
+// RaceForHash returns the race for a race hash, as stored in PGCR entries
+func RaceForHash(hash int64) DestinyRace {
+	if definitions != nil {
+		if definition, ok := definitions.Races[hash]; ok {
+			return DestinyRace(definition.RaceType)
+		}
+	}
+
+	return RaceUnknown
+}
+
+// GenderForHash returns the gender for a gender hash, as stored in PGCR entries
+func GenderForHash(hash int64) DestinyGender {
+	if definitions != nil {
+		if definition, ok := definitions.Genders[hash]; ok {
+			return DestinyGender(definition.GenderType)
+		}
+	}
+
+	return GenderUnknown
 }
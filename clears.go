@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ClearKind classifies how a member took part in a raid or dungeon run
+type ClearKind string
+
+// Clear kinds
+const (
+	ClearFresh      ClearKind = "fresh"
+	ClearCheckpoint ClearKind = "checkpoint"
+	ClearPartial    ClearKind = "partial"
+)
+
+// freshClearGrace is how many seconds after an instance started a player may join and still have played it from the
+// start, as fireteams do not all load in at the same moment
+const freshClearGrace = 120
+
+// Clear is a member taking part in a raid or dungeon run. With lists the other players that cleared the same instance.
+type Clear struct {
+	InstanceID   string
+	Period       time.Time
+	Activity     string
+	Category     string
+	MembershipID string
+	Kind         ClearKind
+	Duration     time.Duration
+	With         []string
+}
+
+// ClearRecord holds the runs of a member for a single raid or dungeon. Clears, FirstClear and Fastest only count fresh
+// clears, LastClear counts checkpoint clears as well.
+type ClearRecord struct {
+	MembershipID     string
+	DisplayName      string
+	Activity         string
+	Category         string
+	Clears           int
+	CheckpointClears int
+	PartialRuns      int
+	FirstClear       time.Time
+	LastClear        time.Time
+	Fastest          time.Duration
+	ClearedWith      []CoPlayPartner
+}
+
+// RunKinds classifies the run of every player in an activity. A player that did not clear it made a partial run. A
+// clear is fresh when the player joined within freshClearGrace of the start of the instance, unless the report says the
+// instance itself was started from a checkpoint, which is only known for reports stored since that was recorded.
+// Players that swapped characters during the run are judged by the character that joined first.
+func RunKinds(activity PGCR) map[string]ClearKind {
+	cleared := map[string]bool{}
+	joined := map[string]float64{}
+	for _, entry := range activity.Entries {
+		membershipID := entry.Player.DestinyUserInfo.MembershipID
+		if EntryCleared(entry.Values.Completed.Basic.Value, entry.Values.CompletionReason.Basic.Value) {
+			cleared[membershipID] = true
+		}
+		if start, ok := joined[membershipID]; !ok || entry.Values.StartSeconds.Basic.Value < start {
+			joined[membershipID] = entry.Values.StartSeconds.Basic.Value
+		}
+	}
+
+	fromCheckpoint := activity.ActivityWasStartedFromBeginning != nil && !*activity.ActivityWasStartedFromBeginning
+	kinds := map[string]ClearKind{}
+	for membershipID, start := range joined {
+		switch {
+		case !cleared[membershipID]:
+			kinds[membershipID] = ClearPartial
+		case fromCheckpoint || start > freshClearGrace:
+			kinds[membershipID] = ClearCheckpoint
+		default:
+			kinds[membershipID] = ClearFresh
+		}
+	}
+
+	return kinds
+}
+
+// MemberClears returns every raid and dungeon run of the players, oldest first, classified by RunKinds. Activities are
+// named from the manifest, so that all versions of a raid sharing a name count as the same raid. With categories given,
+// only runs of activities in those categories are returned. Runs on deleted characters only count when those are
+// included.
+func MemberClears(players []Player, categories []string, includeDeleted bool) ([]Clear, error) {
+	categoryModes, err := CategoryModes(categories)
+	if err != nil {
+		return nil, err
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+
+	memberIDs := []string{}
+	for _, player := range players {
+		memberIDs = append(memberIDs, player.MembershipID)
+	}
+
+	raids := DescendantModes([]DestinyActivityModeType{ModeRaid})
+	dungeons := DescendantModes([]DestinyActivityModeType{ModeDungeon})
+	query := bson.M{
+		"ActivityDetails.Modes":                       bson.M{"$in": append(append([]DestinyActivityModeType{}, raids...), dungeons...)},
+		"Entries.Player.DestinyUserInfo.MembershipID": bson.M{"$in": memberIDs},
+	}
+	if len(categoryModes) > 0 {
+		query["$and"] = []bson.M{{"ActivityDetails.Modes": bson.M{"$in": categoryModes}}}
+	}
+
+	clears := []Clear{}
+	var activity PGCR
+	iter := collectionActivities.Find(query).Sort("Period").Iter()
+	for iter.Next(&activity) {
+		DropCharacterEntries(&activity, excludedCharacters)
+		category := "Dungeon"
+		for _, mode := range activity.ActivityDetails.Modes {
+			if ContainsMode(raids, mode) {
+				category = "Raid"
+				break
+			}
+		}
+
+		kinds := RunKinds(activity)
+		cleared := []string{}
+		for _, membershipID := range memberIDs {
+			if kind, ok := kinds[membershipID]; ok && kind != ClearPartial {
+				cleared = append(cleared, membershipID)
+			}
+		}
+
+		for _, membershipID := range memberIDs {
+			kind, ok := kinds[membershipID]
+			if !ok {
+				continue
+			}
+			clear := Clear{
+				InstanceID:   activity.ActivityDetails.InstanceID,
+				Period:       activity.Period,
+				Activity:     ActivityName(activity.ActivityDetails.ReferenceID),
+				Category:     category,
+				MembershipID: membershipID,
+				Kind:         kind,
+				With:         []string{},
+			}
+			for _, entry := range activity.Entries {
+				if entry.Player.DestinyUserInfo.MembershipID == membershipID {
+					clear.Duration = time.Duration(entry.Values.ActivityDurationSeconds.Basic.Value) * time.Second
+					break
+				}
+			}
+			for _, other := range cleared {
+				if other != membershipID {
+					clear.With = append(clear.With, other)
+				}
+			}
+			clears = append(clears, clear)
+		}
+		activity = PGCR{}
+	}
+
+	return clears, iter.Close()
+}
+
+// ClearRecords totals the clears by member and activity, sorted by member name and activity
+func ClearRecords(players []Player, clears []Clear) []ClearRecord {
+	names := map[string]string{}
+	for _, player := range players {
+		names[player.MembershipID] = player.DisplayName
+	}
+
+	records := map[string]*ClearRecord{}
+	partners := map[string]map[string]int{}
+	for _, clear := range clears {
+		key := clear.MembershipID + "|" + clear.Activity
+		record, ok := records[key]
+		if !ok {
+			record = &ClearRecord{
+				MembershipID: clear.MembershipID,
+				DisplayName:  names[clear.MembershipID],
+				Activity:     clear.Activity,
+				Category:     clear.Category,
+			}
+			records[key] = record
+			partners[key] = map[string]int{}
+		}
+
+		switch clear.Kind {
+		case ClearPartial:
+			record.PartialRuns++
+			continue
+		case ClearCheckpoint:
+			record.CheckpointClears++
+		case ClearFresh:
+			record.Clears++
+			if record.FirstClear.IsZero() || clear.Period.Before(record.FirstClear) {
+				record.FirstClear = clear.Period
+			}
+			if clear.Duration > 0 && (record.Fastest == 0 || clear.Duration < record.Fastest) {
+				record.Fastest = clear.Duration
+			}
+		}
+		if clear.Period.After(record.LastClear) {
+			record.LastClear = clear.Period
+		}
+		for _, partnerID := range clear.With {
+			partners[key][partnerID]++
+		}
+	}
+
+	result := []ClearRecord{}
+	for key, record := range records {
+		record.ClearedWith = []CoPlayPartner{}
+		for partnerID, count := range partners[key] {
+			record.ClearedWith = append(record.ClearedWith, CoPlayPartner{DisplayName: names[partnerID], Activities: count})
+		}
+		sort.Slice(record.ClearedWith, func(i, j int) bool {
+			if record.ClearedWith[i].Activities == record.ClearedWith[j].Activities {
+				return record.ClearedWith[i].DisplayName < record.ClearedWith[j].DisplayName
+			}
+			return record.ClearedWith[i].Activities > record.ClearedWith[j].Activities
+		})
+		result = append(result, *record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !strings.EqualFold(result[i].DisplayName, result[j].DisplayName) {
+			return strings.ToLower(result[i].DisplayName) < strings.ToLower(result[j].DisplayName)
+		}
+		return result[i].Activity < result[j].Activity
+	})
+
+	return result
+}
+
+// ClearsReport writes the raid and dungeon runs of every enabled member by activity, with their fresh, checkpoint and
+// partial runs, their first and fastest fresh clear and the members they cleared with, limited to the activities in the
+// given categories
+func ClearsReport(postfix string, categories []string, includeDeleted bool) error {
+	players, err := GroupMembers("clan")
+	if err != nil {
+		return err
+	}
+	clears, err := MemberClears(players, categories, includeDeleted)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f.WriteString("player\tmembershipId\tcategory\tactivity\tclears\tcheckpointClears\tpartialRuns\tfirstClear\tlastClear\tfastest\tclearedWith\r\n")
+	fresh := 0
+	for _, record := range ClearRecords(players, clears) {
+		fresh += record.Clears
+		firstClear := ""
+		if !record.FirstClear.IsZero() {
+			firstClear = record.FirstClear.Format("2006-01-02")
+		}
+		lastClear := ""
+		if !record.LastClear.IsZero() {
+			lastClear = record.LastClear.Format("2006-01-02")
+		}
+		partners := []string{}
+		for _, partner := range record.ClearedWith {
+			partners = append(partners, fmt.Sprintf("%s (%d)", partner.DisplayName, partner.Activities))
+		}
+		f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\r\n",
+			record.DisplayName, record.MembershipID, record.Category, record.Activity, record.Clears, record.CheckpointClears,
+			record.PartialRuns, firstClear, lastClear, record.Fastest.String(), strings.Join(partners, ", ")))
+	}
+
+	fmt.Printf("%d fresh clears in %d runs by %d members\r\n", fresh, len(clears), len(players))
+	return nil
+}
+
+// FirstClearNeeded writes which enabled members have never made a fresh clear of an activity, matched by a case
+// insensitive part of its name, followed by the members that have, fewest clears first. Members that only cleared it
+// from a checkpoint still need a first clear.
+func FirstClearNeeded(activity string, postfix string, categories []string, includeDeleted bool) error {
+	players, err := GroupMembers("clan")
+	if err != nil {
+		return err
+	}
+	clears, err := MemberClears(players, categories, includeDeleted)
+	if err != nil {
+		return err
+	}
+
+	cleared := map[string]ClearRecord{}
+	names := []string{}
+	for _, record := range ClearRecords(players, clears) {
+		if !strings.Contains(strings.ToLower(record.Activity), strings.ToLower(activity)) {
+			continue
+		}
+		if !ContainsString(names, record.Activity) {
+			names = append(names, record.Activity)
+		}
+
+		// Records of different versions of the activity are merged
+		if existing, ok := cleared[record.MembershipID]; ok {
+			record.Clears += existing.Clears
+			record.CheckpointClears += existing.CheckpointClears
+			record.PartialRuns += existing.PartialRuns
+			if record.FirstClear.IsZero() || (!existing.FirstClear.IsZero() && existing.FirstClear.Before(record.FirstClear)) {
+				record.FirstClear = existing.FirstClear
+			}
+		}
+		cleared[record.MembershipID] = record
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no clears found of an activity named %s", activity)
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Activity: %s\r\n", strings.Join(names, ", "))
+	f.WriteString("player\tmembershipId\tstatus\tclears\tcheckpointClears\tpartialRuns\tfirstClear\r\n")
+	sort.Slice(players, func(i, j int) bool {
+		if cleared[players[i].MembershipID].Clears != cleared[players[j].MembershipID].Clears {
+			return cleared[players[i].MembershipID].Clears < cleared[players[j].MembershipID].Clears
+		}
+		return strings.ToLower(players[i].DisplayName) < strings.ToLower(players[j].DisplayName)
+	})
+	needed := 0
+	for _, player := range players {
+		record := cleared[player.MembershipID]
+		status := "cleared"
+		firstClear := ""
+		switch {
+		case record.Clears > 0:
+			firstClear = record.FirstClear.Format("2006-01-02")
+		case record.CheckpointClears > 0:
+			status = "needs first clear (checkpoint clears only)"
+		default:
+			status = "needs first clear"
+		}
+		if record.Clears == 0 {
+			needed++
+			fmt.Printf("Needs a first clear: %s\r\n", player.DisplayName)
+		}
+		f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\r\n", player.DisplayName, player.MembershipID, status, record.Clears,
+			record.CheckpointClears, record.PartialRuns, firstClear))
+	}
+
+	fmt.Printf("%d of %d members need a first clear\r\n", needed, len(players))
+	return nil
+}
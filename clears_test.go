@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testEntry is a player entry of a test activity report
+type testEntry struct {
+	membershipID     string
+	startSeconds     float64
+	playedSeconds    float64
+	completed        float64
+	completionReason float64
+	fireteamID       string
+}
+
+// testActivity builds an activity report from entries, the way it is decoded from the API
+func testActivity(t *testing.T, period time.Time, entries ...testEntry) PGCR {
+	value := func(v float64) map[string]interface{} {
+		return map[string]interface{}{"basic": map[string]interface{}{"value": v}}
+	}
+
+	report := map[string]interface{}{"period": period}
+	list := []interface{}{}
+	for _, entry := range entries {
+		list = append(list, map[string]interface{}{
+			"player": map[string]interface{}{"destinyUserInfo": map[string]interface{}{"membershipId": entry.membershipID}},
+			"values": map[string]interface{}{
+				"startSeconds":      value(entry.startSeconds),
+				"timePlayedSeconds": value(entry.playedSeconds),
+				"completed":         value(entry.completed),
+				"completionReason":  value(entry.completionReason),
+				"fireteamId":        map[string]interface{}{"basic": map[string]interface{}{"displayValue": entry.fireteamID}},
+			},
+		})
+	}
+	report["entries"] = list
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var activity PGCR
+	err = json.Unmarshal(data, &activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return activity
+}
+
+func TestRunKinds(t *testing.T) {
+	period := time.Date(2019, time.June, 3, 20, 0, 0, 0, time.UTC)
+	fromBeginning := true
+	fromCheckpoint := false
+	tests := []struct {
+		name          string
+		fromBeginning *bool
+		entries       []testEntry
+		want          map[string]ClearKind
+	}{
+		{
+			name: "fresh, late and failed runs",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 0, completed: 1},
+				{membershipID: "2", startSeconds: freshClearGrace, completed: 1},
+				{membershipID: "3", startSeconds: freshClearGrace + 1, completed: 1},
+				{membershipID: "4", startSeconds: 0, completed: 0},
+			},
+			want: map[string]ClearKind{"1": ClearFresh, "2": ClearFresh, "3": ClearCheckpoint, "4": ClearPartial},
+		},
+		{
+			name: "completed but left before the end",
+			entries: []testEntry{
+				{membershipID: "1", completed: 1, completionReason: 0},
+				{membershipID: "2", completed: 1, completionReason: 2},
+			},
+			want: map[string]ClearKind{"1": ClearFresh, "2": ClearPartial},
+		},
+		{
+			name:          "instance started from a checkpoint",
+			fromBeginning: &fromCheckpoint,
+			entries:       []testEntry{{membershipID: "1", completed: 1}},
+			want:          map[string]ClearKind{"1": ClearCheckpoint},
+		},
+		{
+			name:          "instance started from the beginning",
+			fromBeginning: &fromBeginning,
+			entries:       []testEntry{{membershipID: "1", completed: 1}},
+			want:          map[string]ClearKind{"1": ClearFresh},
+		},
+		{
+			name: "character swap judged by the first character",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 0, completed: 0},
+				{membershipID: "1", startSeconds: 900, completed: 1},
+			},
+			want: map[string]ClearKind{"1": ClearFresh},
+		},
+		{
+			name:    "no entries",
+			entries: []testEntry{},
+			want:    map[string]ClearKind{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activity := testActivity(t, period, test.entries...)
+			activity.ActivityWasStartedFromBeginning = test.fromBeginning
+			got := RunKinds(activity)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("RunKinds() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// command is a subcommand run by naming it as the first command line argument
+type command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+func commandList() []command {
+	return []command{
+		{Name: "event-times", Description: "Recommend the best times for a clan event", Run: EventTimesCommand},
+		{Name: "inactive", Description: "List members that have not played for a number of days", Run: InactiveCommand},
+		{Name: "stats-progress", Description: "Compare the stats of two stats batches", Run: StatsProgressCommand},
+		{Name: "activity-progress", Description: "Compare the per activity stats of two stats batches", Run: ActivityProgressCommand},
+		{Name: "leaderboard", Description: "Rank members by a stat", Run: LeaderboardCommand},
+		{Name: "activity-stats", Description: "Store the per activity stats of all characters as a new stats batch", Run: ActivityStatsCommand},
+		{Name: "stats-batches", Description: "List stats batches, or delete one with -delete or end a stopped one with -mark-partial", Run: StatsBatchesCommand},
+		{Name: "clears", Description: "List raid and dungeon clears, or who needs a first clear with -activity", Run: ClearsCommand},
+		{Name: "sessions", Description: "Report on play sessions, rebuilding them first with -build", Run: SessionsCommand},
+		{Name: "integration", Description: "Trend the share of play with clan members, outsiders and solo", Run: IntegrationCommand},
+		{Name: "coplay-ranking", Description: "Rank pairs of members by the hours they played together", Run: CoPlayRankingCommand},
+		{Name: "weapons", Description: "List the most used weapons of every member and the clan", Run: WeaponsCommand},
+		{Name: "graph-evolution", Description: "Track the co-play graph and its communities over weekly or monthly windows", Run: GraphEvolutionCommand},
+	}
+}
+
+// RunCommand runs the command named by the first argument
+func RunCommand(args []string) {
+	for _, cmd := range commandList() {
+		if cmd.Name == args[0] {
+			err := cmd.Run(args[1:])
+			if err != nil {
+				fmt.Printf("Error running %s: %s\r\n", cmd.Name, err.Error())
+			}
+			return
+		}
+	}
+
+	fmt.Printf("Unknown command %s, available commands:\r\n", args[0])
+	for _, cmd := range commandList() {
+		fmt.Printf("  %-16s%s\r\n", cmd.Name, cmd.Description)
+	}
+}
+
+// parseDate parses a date flag, returning the fallback when the flag is not set
+func parseDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	return time.Parse("2006-01-02", value)
+}
+
+// parseEndDate parses the date flag ending a date window, returning the fallback when the flag is not set. Windows
+// include their last day, so the start of the next day is returned.
+func parseEndDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return date.AddDate(0, 0, 1), nil
+}
+
+// defaultIncludeDeleted is whether reports use the activity of deleted characters when not told otherwise
+const defaultIncludeDeleted = false
+
+// addIncludeDeletedFlag adds the flag shared by the reports that choose whether the activity of deleted characters
+// counts
+func addIncludeDeletedFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("include-deleted", defaultIncludeDeleted, "include the activity of deleted characters")
+}
+
+// parseCoPlayMode parses the mode flag of the co-play reports
+func parseCoPlayMode(value string) (CoPlayMode, error) {
+	switch value {
+	case "fireteam":
+		return CoPlayFireteam, nil
+	case "instance":
+		return CoPlayInstance, nil
+	}
+
+	return CoPlayInstance, fmt.Errorf("unsupported mode %s", value)
+}
+
+// parseWindowPeriod parses the period flag of the reports trending over weekly or monthly windows
+func parseWindowPeriod(value string) (WindowPeriod, error) {
+	switch value {
+	case "weekly":
+		return WindowWeekly, nil
+	case "monthly":
+		return WindowMonthly, nil
+	}
+
+	return WindowWeekly, fmt.Errorf("unsupported period %s", value)
+}
+
+// addCategoriesFlag adds the activity category filter shared by the reports. Besides category names such as Raid or
+// PvP, the name or number of a single activity mode can be given.
+func addCategoriesFlag(flags *flag.FlagSet) *string {
+	return flags.String("categories", "", "comma separated activity categories or modes to use (default all)")
+}
+
+// addStatCategoriesFlag adds the category filter of the reports on stored stats batches, which only hold PvE and PvP
+// totals
+func addStatCategoriesFlag(flags *flag.FlagSet) *string {
+	return flags.String("categories", "", "PvE, PvP or both, the only categories stats batches are split in (default both)")
+}
+
+// EventTimesCommand recommends event times from the play history of a target group
+func EventTimesCommand(args []string) error {
+	flags := flag.NewFlagSet("event-times", flag.ContinueOnError)
+	from := flags.String("from", "", "first date of the play history to use (YYYY-MM-DD, default 12 weeks ago)")
+	to := flags.String("to", "", "last date of the play history to use (YYYY-MM-DD, default yesterday)")
+	group := flags.String("group", "clan", "clan, a clan role, a member tag or a comma separated list of members")
+	duration := flags.Duration("duration", 2*time.Hour, "event duration")
+	weekdays := flags.String("weekdays", "", "comma separated weekdays the event may start on (default any)")
+	top := flags.Int("top", 5, "number of windows to return")
+	minimum := flags.Int("min", 1, "attendance needed for a week to count towards the confidence")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	zone := flags.String("zone", "", "timezone of the recommended times (default the clan timezone)")
+	resolution := flags.Int("resolution", 30, "start time resolution in minutes (15, 30 or 60)")
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_eventtimes", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	endDate, err := parseEndDate(*to, today)
+	if err != nil {
+		return err
+	}
+	startDate, err := parseDate(*from, endDate.AddDate(0, 0, -12*7))
+	if err != nil {
+		return err
+	}
+	days, err := ParseWeekdays(*weekdays)
+	if err != nil {
+		return err
+	}
+
+	_, err = RecommendEventTimes(startDate, endDate, *postfix, *group, *duration, days, *top, *minimum, SplitList(*categories), *includeDeleted, *zone, *resolution)
+	return err
+}
+
+// InactiveCommand writes the inactivity report
+func InactiveCommand(args []string) error {
+	flags := flag.NewFlagSet("inactive", flag.ContinueOnError)
+	days := flags.Int("days", 30, "days without activity after which a member is inactive")
+	coPlayDays := flags.Int("coplay-days", 90, "days before a member was last seen to count co-play over")
+	sortBy := flags.String("sort", SortLastSeen, "sort order: lastseen, name, tenure or coplay")
+	format := flags.String("format", "tsv", "output format: tsv or json")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_inactive", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return InactivityReport(*days, *coPlayDays, *sortBy, *postfix, *format, SplitList(*categories), *includeDeleted)
+}
+
+// batchRange holds the flags choosing two stats batches to compare, by ID or by date
+type batchRange struct {
+	fromBatch *int
+	toBatch   *int
+	fromDate  *string
+	toDate    *string
+	all       *bool
+}
+
+func addBatchRangeFlags(flags *flag.FlagSet) batchRange {
+	return batchRange{
+		fromBatch: flags.Int("from-batch", 0, "batch to compare from"),
+		toBatch:   flags.Int("to-batch", 0, "batch to compare to"),
+		fromDate:  flags.String("from-date", "", "compare from the last batch on or before this date (YYYY-MM-DD)"),
+		toDate:    flags.String("to-date", "", "compare to the last batch on or before this date (YYYY-MM-DD)"),
+		all:       flags.Bool("all", false, "also use running and partial batches, including those given by ID"),
+	}
+}
+
+// resolve returns the batches to compare. Without batch IDs or dates, the last two batches of the kind are compared.
+// Batches given by ID must be complete batches of the kind, unless incomplete batches are allowed.
+func (r batchRange) resolve(kind string) (int, int, error) {
+	batches, err := StatsBatches(kind, *r.all)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(batches) < 2 {
+		return 0, 0, fmt.Errorf("at least two stats batches are needed")
+	}
+
+	for _, batchID := range []int{*r.fromBatch, *r.toBatch} {
+		if batchID == 0 {
+			continue
+		}
+		found := false
+		for _, batch := range batches {
+			found = found || batch.BatchID == batchID
+		}
+		if !found && *r.all {
+			return 0, 0, fmt.Errorf("batch %d is not a %s batch", batchID, kind)
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("batch %d is not a complete %s batch, use -all to compare incomplete batches", batchID, kind)
+		}
+	}
+
+	from, to := *r.fromBatch, *r.toBatch
+	if to == 0 {
+		to = batches[len(batches)-1].BatchID
+		if *r.toDate != "" {
+			date, err := time.Parse("2006-01-02", *r.toDate)
+			if err != nil {
+				return 0, 0, err
+			}
+			if to, err = StatsBatchAt(kind, date.AddDate(0, 0, 1), *r.all); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if from == 0 {
+		for _, batch := range batches {
+			if batch.BatchID < to {
+				from = batch.BatchID
+			}
+		}
+		if *r.fromDate != "" {
+			date, err := time.Parse("2006-01-02", *r.fromDate)
+			if err != nil {
+				return 0, 0, err
+			}
+			if from, err = StatsBatchAt(kind, date.AddDate(0, 0, 1), *r.all); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return from, to, nil
+}
+
+// StatsProgressCommand compares two player stats batches
+func StatsProgressCommand(args []string) error {
+	flags := flag.NewFlagSet("stats-progress", flag.ContinueOnError)
+	batches := addBatchRangeFlags(flags)
+	categories := addStatCategoriesFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_progress", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, to, err := batches.resolve(BatchPlayerStats)
+	if err != nil {
+		return err
+	}
+
+	return StatsProgress(from, to, *postfix, SplitList(*categories))
+}
+
+// ActivityProgressCommand compares two activity stats batches
+func ActivityProgressCommand(args []string) error {
+	flags := flag.NewFlagSet("activity-progress", flag.ContinueOnError)
+	batches := addBatchRangeFlags(flags)
+	categories := addCategoriesFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_activityprogress", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, to, err := batches.resolve(BatchActivityStats)
+	if err != nil {
+		return err
+	}
+
+	return ActivityStatsProgress(from, to, *postfix, SplitList(*categories))
+}
+
+// ActivityStatsCommand retrieves the per activity stats of the enabled members as a new stats batch
+func ActivityStatsCommand(args []string) error {
+	flags := flag.NewFlagSet("activity-stats", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return RetrievePlayersAggregateStats()
+}
+
+// StatsBatchesCommand lists all stats batches, deletes a single batch or marks a batch whose run stopped as partial
+func StatsBatchesCommand(args []string) error {
+	flags := flag.NewFlagSet("stats-batches", flag.ContinueOnError)
+	deleteBatch := flags.Int("delete", 0, "batch to delete, along with its stats")
+	partialBatch := flags.Int("mark-partial", 0, "running batch whose run stopped, to mark as partial")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *deleteBatch != 0 {
+		return DeleteStatsBatch(*deleteBatch)
+	}
+	if *partialBatch != 0 {
+		return MarkStatsBatchPartial(*partialBatch)
+	}
+
+	batches, err := StatsBatches("", true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("BatchID\tKind\tStatus\tStart\tEnd\tMembers\tRetrieved\tFailed\r\n")
+	for _, batch := range batches {
+		end := ""
+		if !batch.EndTime.IsZero() {
+			end = batch.EndTime.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\r\n", batch.BatchID, batch.Kind, batch.Status, batch.StartTime.Format("2006-01-02 15:04:05"), end, batch.Members, batch.Retrieved, batch.Failed)
+	}
+
+	return nil
+}
+
+// LeaderboardCommand ranks the members by a stat, within a date window or season
+func LeaderboardCommand(args []string) error {
+	flags := flag.NewFlagSet("leaderboard", flag.ContinueOnError)
+	stat := flags.String("stat", "", "PlayerStats field such as PvP.Kills, raid-clears, dungeon-clears, flawless or precision-ratio, with /hour for a rate")
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default all time)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	season := flags.String("season", "", "season from the configuration to use as the date window")
+	top := flags.Int("top", 0, "number of ranks to show (default all)")
+	categories := flags.String("categories", "", "comma separated activity categories or modes to use (default all), PvE or PvP for PlayerStats fields")
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_leaderboard", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *stat == "" {
+		return fmt.Errorf("a stat is required")
+	}
+
+	var startDate, endDate time.Time
+	var err error
+	if *season != "" {
+		startDate, endDate, err = LookupSeason(*season)
+	} else {
+		startDate, err = parseDate(*from, time.Time{})
+		if err == nil {
+			endDate, err = parseEndDate(*to, time.Time{})
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, err := Leaderboard(*stat, startDate, endDate, SplitList(*categories), *includeDeleted)
+	if err != nil {
+		return err
+	}
+
+	return WriteLeaderboard(entries, *stat, *postfix, *top)
+}
+
+// ClearsCommand writes the raid and dungeon clears of every member, or with -activity the members that still need a
+// first clear of that activity
+func ClearsCommand(args []string) error {
+	flags := flag.NewFlagSet("clears", flag.ContinueOnError)
+	activity := flags.String("activity", "", "part of the name of a raid or dungeon to list the members needing a first clear of")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", "", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *activity != "" {
+		if *postfix == "" {
+			*postfix = time.Now().Format("060102") + "_firstclear"
+		}
+		return FirstClearNeeded(*activity, *postfix, SplitList(*categories), *includeDeleted)
+	}
+	if *postfix == "" {
+		*postfix = time.Now().Format("060102") + "_clears"
+	}
+	return ClearsReport(*postfix, SplitList(*categories), *includeDeleted)
+}
+
+// SessionsCommand rebuilds the stored play sessions when asked to and reports on the sessions in a date window
+func SessionsCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions", flag.ContinueOnError)
+	build := flags.Bool("build", false, "first delete and rebuild the stored sessions of every member from all their stored activities")
+	gap := flags.Int("gap", SessionMaxGap(), "maximum gap in minutes between the activities of a session")
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default the first session)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_sessions", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	startDate, err := parseDate(*from, time.Time{})
+	if err != nil {
+		return err
+	}
+	endDate, err := parseEndDate(*to, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *build {
+		err = BuildSessions(*gap, *includeDeleted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return SessionReport(startDate, endDate, *postfix, SplitList(*categories))
+}
+
+// IntegrationCommand writes the share of every member's activities and playtime spent with clan members, with
+// outsiders and solo, by week or month
+func IntegrationCommand(args []string) error {
+	flags := flag.NewFlagSet("integration", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default 6 months ago)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	period := flags.String("period", "monthly", "trend period: weekly or monthly")
+	mode := flags.String("mode", "fireteam", "who counts as company: fireteam or instance")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_integration", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	endDate, err := parseEndDate(*to, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	startDate, err := parseDate(*from, endDate.AddDate(0, -6, 0))
+	if err != nil {
+		return err
+	}
+
+	windowPeriod, err := parseWindowPeriod(*period)
+	if err != nil {
+		return err
+	}
+	coPlayMode, err := parseCoPlayMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	return ClanIntegration(startDate, endDate, *postfix, windowPeriod, *includeDeleted, SplitList(*categories), coPlayMode)
+}
+
+// CoPlayRankingCommand writes every pair of members ranked by the hours they played together
+func CoPlayRankingCommand(args []string) error {
+	flags := flag.NewFlagSet("coplay-ranking", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default 6 months ago)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	mode := flags.String("mode", "fireteam", "who played together: fireteam or instance")
+	completed := flags.Bool("completed", false, "only count the time of players that completed the activity")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_coplay", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	endDate, err := parseEndDate(*to, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	startDate, err := parseDate(*from, endDate.AddDate(0, -6, 0))
+	if err != nil {
+		return err
+	}
+	coPlayMode, err := parseCoPlayMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	CoPlayRanking(startDate, endDate, *postfix, *includeDeleted, SplitList(*categories), coPlayMode, *completed)
+	return nil
+}
+
+// WeaponsCommand writes the most used weapons of every member and of the clan in a date window
+func WeaponsCommand(args []string) error {
+	flags := flag.NewFlagSet("weapons", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default a month ago)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_weapons", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	endDate, err := parseEndDate(*to, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	startDate, err := parseDate(*from, endDate.AddDate(0, -1, 0))
+	if err != nil {
+		return err
+	}
+
+	MostUsedWeapons(startDate, endDate, *postfix, SplitList(*categories), *includeDeleted)
+	return nil
+}
+
+// GraphEvolutionCommand writes the timeline of the co-play graph over weekly or monthly windows
+func GraphEvolutionCommand(args []string) error {
+	flags := flag.NewFlagSet("graph-evolution", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the date window (YYYY-MM-DD, default 6 months ago)")
+	to := flags.String("to", "", "last day of the date window (YYYY-MM-DD, default today)")
+	period := flags.String("period", "monthly", "window period: weekly or monthly")
+	rolling := flags.Bool("rolling", false, "use rolling windows, advancing a day at a time for weekly and a week for monthly windows")
+	mode := flags.String("mode", "fireteam", "who played together: fireteam or instance")
+	weight := flags.String("weight", "time", "link weight: time or activities")
+	completed := flags.Bool("completed", false, "only count players that completed the activity")
+	format := flags.String("format", GraphJSON, "timeline format: json or gexf")
+	categories := addCategoriesFlag(flags)
+	includeDeleted := addIncludeDeletedFlag(flags)
+	postfix := flags.String("postfix", time.Now().Format("060102")+"_timeline", "output file postfix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	endDate, err := parseEndDate(*to, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	startDate, err := parseDate(*from, endDate.AddDate(0, -6, 0))
+	if err != nil {
+		return err
+	}
+	windowPeriod, err := parseWindowPeriod(*period)
+	if err != nil {
+		return err
+	}
+	coPlayMode, err := parseCoPlayMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	var edgeWeight EdgeWeight
+	switch *weight {
+	case "time":
+		edgeWeight = WeightTimePlayed
+	case "activities":
+		edgeWeight = WeightActivities
+	default:
+		return fmt.Errorf("unsupported weight %s", *weight)
+	}
+
+	GraphEvolution(startDate, endDate, *postfix, windowPeriod, *rolling, *includeDeleted, SplitList(*categories), coPlayMode, edgeWeight, *completed, *format)
+	return nil
+}
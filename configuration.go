@@ -11,12 +11,22 @@ import (
 
 // Configuration contains system wide configuration values
 type Configuration struct {
-	APIKey            string `yaml:"APIKey"`
-	ClanID            string `yaml:"ClanID"`
-	MembershipType    string `yaml:"MembershipType"`
-	ActivityBatchSize int    `yaml:"ActivityBatchSize"`
-	ActivityAgeCutoff int    `yaml:"ActivityAgeCutoff"`
-	MongoDB           string `yaml:"MongoDB"`
+	APIKey              string              `yaml:"APIKey"`
+	ClanID              string              `yaml:"ClanID"`
+	MembershipType      string              `yaml:"MembershipType"`
+	ActivityBatchSize   int                 `yaml:"ActivityBatchSize"`
+	ActivityAgeCutoff   int                 `yaml:"ActivityAgeCutoff"`
+	ActivityModes       []int               `yaml:"ActivityModes"`
+	MongoDB             string              `yaml:"MongoDB"`
+	ManifestPath        string              `yaml:"ManifestPath"`
+	ManifestURL         string              `yaml:"ManifestURL"`
+	ManifestLocale      string              `yaml:"ManifestLocale"`
+	Timezone            string              `yaml:"Timezone"`
+	MemberTimezones     map[string]string   `yaml:"MemberTimezones"`
+	MemberTimezonesPath string              `yaml:"MemberTimezonesPath"`
+	MemberTags          map[string][]string `yaml:"MemberTags"`
+	Seasons             []Season            `yaml:"Seasons"`
+	SessionMaxGap       int                 `yaml:"SessionMaxGap"`
 }
 
 // ReadConfig reads system configuration from a YAML config file and returns a Configuration struct
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"sort"
+	"strconv"
 	"time"
 
 	mgo "gopkg.in/mgo.v2"
@@ -23,6 +26,40 @@ func RetrieveMembers() error {
 	// Get list of Members from API
 	apiPlayers := GetMembers()
 
+	// Move members stored under another of their cross save accounts to their primary account, so that they are not
+	// disabled below and keep their history
+	moved := false
+	for _, player := range apiPlayers {
+		for _, linkedID := range player.LinkedMembershipIDs {
+			if linkedID == player.MembershipID {
+				continue
+			}
+			count, err := collectionMembers.Find(bson.M{"MembershipID": linkedID}).Count()
+			if err != nil {
+				fmt.Printf("Error reading members: %s\r\n", err.Error())
+				return err
+			}
+			if count == 0 {
+				continue
+			}
+
+			fmt.Printf("Moving member: %s (%s to %s)\r\n", player.DisplayName, linkedID, player.MembershipID)
+			err = RekeyMember(linkedID, player)
+			if err != nil {
+				fmt.Printf("Error moving member: %s\r\n", err.Error())
+				return err
+			}
+			moved = true
+		}
+	}
+	if moved {
+		err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+		if err != nil {
+			fmt.Printf("Error reading members: %s\r\n", err.Error())
+			return err
+		}
+	}
+
 	// Disable players in DB that are no longer in clan
 	for _, player := range dbPlayers {
 		if !ContainsMember(apiPlayers, player.MembershipID) {
@@ -56,43 +93,158 @@ func RetrieveMembers() error {
 			}
 			dbPlayers = append(dbPlayers, player)
 			fmt.Printf("New Member: %s (%s)\r\n", player.DisplayName, player.MembershipID)
+		} else {
+			fields := bson.M{"ClanRole": player.ClanRole}
+			if !player.JoinDate.IsZero() {
+				fields["JoinDate"] = player.JoinDate
+			}
+			err = collectionMembers.Update(
+				bson.M{"MembershipID": player.MembershipID},
+				bson.M{"$set": fields},
+			)
+			if err != nil {
+				fmt.Printf("Error updating member: %s\r\n", err.Error())
+			}
 		}
 
 		// Find  all characters for member
-		characters := GetCharacters(player.MembershipID)
+		characters, err := GetCharacters(player.MembershipType, player.MembershipID)
+		if err != nil {
+			fmt.Printf("Error retrieving characters for %s: %s\r\n", player.DisplayName, err.Error())
+			continue
+		}
 
-		// Upsert characters
+		// Upsert characters, restoring any the API left out of an earlier sync and that were marked as deleted
 		//charactersCollection := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
 		for _, character := range characters {
 			colQuerier := bson.M{"CharacterID": character.CharacterID}
-			record := bson.M{"$set": bson.M{
-				"MembershipID":   player.MembershipID,
-				"Race":           character.Race,
-				"Gender":         character.Gender,
-				"Class":          character.Class,
-				"DateLastPlayed": character.DateLastPlayed,
-				"Enabled":        true,
-			}}
+			record := bson.M{
+				"$set": bson.M{
+					"MembershipID":   player.MembershipID,
+					"MembershipType": character.MembershipType,
+					"Race":           character.Race,
+					"Gender":         character.Gender,
+					"Class":          character.Class,
+					"RaceHash":       character.RaceHash,
+					"GenderHash":     character.GenderHash,
+					"ClassHash":      character.ClassHash,
+					"DateLastPlayed": character.DateLastPlayed,
+					"Enabled":        true,
+					"Deleted":        false,
+				},
+				"$unset": bson.M{"DateDeleted": "", "FinalCrawlDone": ""},
+			}
 			//_, err = charactersCollection.Upsert(colQuerier, record)
 			_, err = collectionCharacters.Upsert(colQuerier, record)
 			if err != nil {
 				fmt.Printf("Error inserting character: %s\r\n", err.Error())
 				return err
 			}
-			fmt.Printf("Character updated: %s %s %s for %s\r\n", Gender(character.Gender), Race(character.Race), Class(character.Class), player.DisplayName)
+			fmt.Printf("Character updated: %s %s %s for %s\r\n", character.Gender, character.Race, character.Class, player.DisplayName)
+		}
+
+		// Mark characters that are no longer returned by the API as deleted, so that their history can be crawled one final time
+		var dbCharacters []Character
+		err = collectionCharacters.Find(bson.M{"MembershipID": player.MembershipID, "Deleted": bson.M{"$ne": true}}).All(&dbCharacters)
+		if err != nil {
+			fmt.Printf("Error reading characters: %s\r\n", err.Error())
+			return err
+		}
+		for _, character := range dbCharacters {
+			if !ContainsCharacter(characters, character.CharacterID) {
+				err = collectionCharacters.Update(
+					bson.M{"CharacterID": character.CharacterID},
+					bson.M{"$set": bson.M{
+						"Enabled":     false,
+						"Deleted":     true,
+						"DateDeleted": time.Now(),
+					}},
+				)
+				if err != nil {
+					fmt.Printf("Error marking character as deleted: %s\r\n", err.Error())
+					return err
+				}
+				fmt.Printf("Character deleted: %s %s %s for %s\r\n", character.Gender, character.Race, character.Class, player.DisplayName)
+			}
 		}
 	}
 
 	return nil
 }
 
+// RekeyMember moves a member stored under an old membership ID to the membership ID and type of the player, together
+// with their characters, stats, sessions and activity entries. When the player is already stored under the new ID as
+// well, the old member record is removed and only the related records are moved. Graph snapshots keep the IDs they
+// were taken with.
+func RekeyMember(oldID string, player Player) error {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	count, err := db.C("Members").Find(bson.M{"MembershipID": player.MembershipID}).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		err = db.C("Members").Remove(bson.M{"MembershipID": oldID})
+	} else {
+		err = db.C("Members").Update(
+			bson.M{"MembershipID": oldID},
+			bson.M{"$set": bson.M{
+				"MembershipID":   player.MembershipID,
+				"MembershipType": player.MembershipType,
+				"Enabled":        true,
+			}},
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range []string{"Characters", "PlayerStats", "ActivityStats", "Sessions"} {
+		_, err = db.C(collection).UpdateAll(
+			bson.M{"MembershipID": oldID},
+			bson.M{"$set": bson.M{"MembershipID": player.MembershipID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rekeyArrayField(db.C("Sessions"), "Partners", "MembershipID", oldID, player.MembershipID)
+	if err != nil {
+		return err
+	}
+
+	return rekeyArrayField(db.C("Activities"), "Entries", "Player.DestinyUserInfo.MembershipID", oldID, player.MembershipID)
+}
+
+// rekeyArrayField replaces a membership ID in a field of the elements of an array. The positional operator only
+// updates the first matching element of every document, so the update is repeated until no document is changed.
+func rekeyArrayField(collection *mgo.Collection, array string, field string, oldID string, newID string) error {
+	for {
+		info, err := collection.UpdateAll(
+			bson.M{array + "." + field: oldID},
+			bson.M{"$set": bson.M{array + ".$." + field: newID}},
+		)
+		if err != nil {
+			return err
+		}
+		if info.Updated == 0 {
+			return nil
+		}
+	}
+}
+
 func RetrieveActivities() error {
 	// Get all characters from DB
 	c := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
 	activitiesCollection := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
 
+	// Deleted characters are included until their final history crawl has been done
 	var characters []Character
-	err := c.Find(bson.M{"Enabled": true}).All(&characters)
+	err := c.Find(bson.M{"$or": []bson.M{
+		{"Enabled": true},
+		{"Deleted": true, "FinalCrawlDone": bson.M{"$ne": true}},
+	}}).All(&characters)
 	if err != nil {
 		fmt.Printf("Error reading characters: %s\r\n", err.Error())
 		return err
@@ -100,58 +252,122 @@ func RetrieveActivities() error {
 
 	// Iterate through characters and check if last retrieved activity is old enough to warrant retrieving activities
 	for cnt, character := range characters {
-		if int(character.DateLastPlayed.Sub(character.LastRetrievedDate).Hours()) > config.ActivityAgeCutoff {
-			fmt.Printf("Retrieving activities for %s (%s) [%d of %d]\r\n", Class(character.Class), character.CharacterID, cnt, len(characters))
-			activities := GetActivities(character.MembershipID, character.CharacterID, character.LastRetrievedActivity)
-			lastActivityID := character.LastRetrievedActivity
-			lastActivityDate := character.LastRetrievedDate
-			if lastActivityID == "" && len(activities) > 0 {
-				lastActivityID = activities[0].ActivityDetails.InstanceID
-			}
-			fmt.Printf("Found total of %d\r\n", len(activities))
-
-			// Now iterate through activities and insert into DB if not already inserted
-			retrievedCnt := 0
-			for _, activity := range activities {
-				err = activitiesCollection.Insert(activity)
-				if err != nil {
-					if !mgo.IsDup(err) {
-						fmt.Printf("Error inserting activity: %s\r\n", err.Error())
-						return err
-					} else {
-						retrievedCnt = retrievedCnt + 1
-						fmt.Printf("Activity %s retrieved\r\n", activity.ActivityDetails.InstanceID)
-					}
-				}
-				if activity.Period.After(lastActivityDate) {
-					lastActivityDate = activity.Period
-					lastActivityID = activity.ActivityDetails.InstanceID
-				}
+		// Without configured activity modes all modes are retrieved against the character's own watermark
+		if len(config.ActivityModes) == 0 {
+			watermark := ActivityWatermark{
+				LastRetrievedActivity: character.LastRetrievedActivity,
+				LastRetrievedDate:     character.LastRetrievedDate,
+			}
+			if !character.Deleted && int(character.DateLastPlayed.Sub(watermark.LastRetrievedDate).Hours()) <= config.ActivityAgeCutoff {
+				fmt.Printf("No new activities for %s (%s)\r\n", character.Class, character.CharacterID)
+				continue
 			}
 
-			if retrievedCnt == 0 {
-				lastActivityDate = character.DateLastPlayed
+			fmt.Printf("Retrieving activities for %s (%s) [%d of %d]\r\n", character.Class, character.CharacterID, cnt, len(characters))
+			watermark, crawled, err := RetrieveCharacterActivities(activitiesCollection, character, 0, watermark)
+			if err != nil {
+				return err
 			}
 
-			fmt.Printf("Retrieved total of %d\r\n", retrievedCnt)
+			colQuerier := bson.M{"CharacterID": character.CharacterID}
+			record := bson.M{"$set": bson.M{
+				"LastRetrievedActivity": watermark.LastRetrievedActivity,
+				"LastRetrievedDate":     watermark.LastRetrievedDate,
+				"FinalCrawlDone":        character.Deleted && crawled,
+			}}
+			err = c.Update(colQuerier, record)
+			if err != nil {
+				fmt.Printf("Error updating character: %s\r\n", err.Error())
+				//return err
+			}
+			continue
+		}
+
+		// Otherwise each configured mode is retrieved separately against its own watermark
+		crawledAll := true
+		for _, mode := range config.ActivityModes {
+			watermark := character.ModeWatermarks[strconv.Itoa(mode)]
+			if !character.Deleted && int(character.DateLastPlayed.Sub(watermark.LastRetrievedDate).Hours()) <= config.ActivityAgeCutoff {
+				fmt.Printf("No new activities in mode %d for %s (%s)\r\n", mode, character.Class, character.CharacterID)
+				continue
+			}
+
+			fmt.Printf("Retrieving activities in mode %d for %s (%s) [%d of %d]\r\n", mode, character.Class, character.CharacterID, cnt, len(characters))
+			watermark, crawled, err := RetrieveCharacterActivities(activitiesCollection, character, mode, watermark)
+			if err != nil {
+				return err
+			}
+			crawledAll = crawledAll && crawled
 
 			colQuerier := bson.M{"CharacterID": character.CharacterID}
 			record := bson.M{"$set": bson.M{
-				"LastRetrievedActivity": lastActivityID,
-				"LastRetrievedDate":     lastActivityDate,
+				fmt.Sprintf("ModeWatermarks.%d", mode): watermark,
 			}}
 			err = c.Update(colQuerier, record)
 			if err != nil {
 				fmt.Printf("Error updating character: %s\r\n", err.Error())
 				//return err
 			}
-		} else {
-			fmt.Printf("No new activities for %s (%s)\r\n", Class(character.Class), character.CharacterID)
+		}
+
+		if character.Deleted && crawledAll {
+			err = c.Update(bson.M{"CharacterID": character.CharacterID}, bson.M{"$set": bson.M{"FinalCrawlDone": true}})
+			if err != nil {
+				fmt.Printf("Error updating character: %s\r\n", err.Error())
+			}
 		}
 	}
 	return nil
 }
 
+// RetrieveCharacterActivities retrieves and stores all activities of a character played since the given watermark,
+// limited to a single activity mode unless mode is 0, and returns the updated watermark. The returned flag is false
+// if the activity history could not be retrieved, in which case the watermark is returned unchanged so the history is
+// retrieved again on the next run.
+func RetrieveCharacterActivities(activitiesCollection *mgo.Collection, character Character, mode int, watermark ActivityWatermark) (ActivityWatermark, bool, error) {
+	activities, err := GetActivities(character.MembershipType, character.MembershipID, character.CharacterID, mode, watermark.LastRetrievedActivity)
+	if err != nil {
+		fmt.Printf("Error retrieving activities: %s\r\n", err.Error())
+		return watermark, false, nil
+	}
+	lastActivityID := watermark.LastRetrievedActivity
+	lastActivityDate := watermark.LastRetrievedDate
+	if lastActivityID == "" && len(activities) > 0 {
+		lastActivityID = activities[0].ActivityDetails.InstanceID
+	}
+	fmt.Printf("Found total of %d\r\n", len(activities))
+
+	// Now iterate through activities and insert into DB if not already inserted
+	retrievedCnt := 0
+	for _, activity := range activities {
+		err := activitiesCollection.Insert(activity)
+		if err != nil {
+			if !mgo.IsDup(err) {
+				fmt.Printf("Error inserting activity: %s\r\n", err.Error())
+				return watermark, false, err
+			} else {
+				retrievedCnt = retrievedCnt + 1
+				fmt.Printf("Activity %s retrieved\r\n", activity.ActivityDetails.InstanceID)
+			}
+		}
+		if activity.Period.After(lastActivityDate) {
+			lastActivityDate = activity.Period
+			lastActivityID = activity.ActivityDetails.InstanceID
+		}
+	}
+
+	if retrievedCnt == 0 {
+		lastActivityDate = character.DateLastPlayed
+	}
+
+	fmt.Printf("Retrieved total of %d\r\n", retrievedCnt)
+
+	return ActivityWatermark{
+		LastRetrievedActivity: lastActivityID,
+		LastRetrievedDate:     lastActivityDate,
+	}, true, nil
+}
+
 func RetrievePlayersStats() error {
 	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
 	collectionStats := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("PlayerStats")
@@ -164,13 +380,6 @@ func RetrievePlayersStats() error {
 		return err
 	}
 
-	var dbStats PlayerStats
-	err = collectionStats.Find(bson.M{}).Sort("-BatchID").One(&dbStats)
-	if err != nil {
-		fmt.Printf("Error finding BatchID: %s\r\n", err.Error())
-		//return err
-	}
-
 	var dbHashedActivities []HashedActivityDetails
 	err = collectionStats.Find(bson.M{}).All(&dbHashedActivities)
 	if err != nil {
@@ -178,18 +387,21 @@ func RetrievePlayersStats() error {
 		return err
 	}
 
-	batchID := dbStats.BatchID + 1
-	if batchID == 1 {
-		batchID = 106
+	batch, err := StartStatsBatch(BatchPlayerStats, len(dbPlayers))
+	if err != nil {
+		fmt.Printf("Error starting stats batch: %s\r\n", err.Error())
+		return err
 	}
+	batchID := batch.BatchID
 	fmt.Printf("BatchID: %d\r\n", batchID)
 
 	// Iterate through players
 	for cnt, player := range dbPlayers {
 		fmt.Printf("Player %d/%d - %s (%s)... ", cnt+1, len(dbPlayers), player.DisplayName, player.MembershipID)
-		stats, err := GetMemberStats(player.MembershipID)
+		stats, err := GetMemberStats(player.MembershipType, player.MembershipID)
 		if err != nil {
-			fmt.Printf("%s\r\n", err.Error)
+			fmt.Printf("%s\r\n", err.Error())
+			batch.Failed++
 		} else {
 			newStats := PlayerStats{
 				BatchID:      batchID,
@@ -278,8 +490,11 @@ func RetrievePlayersStats() error {
 			err := collectionStats.Insert(newStats)
 			if err != nil {
 				fmt.Printf("Error inserting stats: %s\r\n", err.Error())
+				batch.Failed++
+				continue
 			}
 
+			batch.Retrieved++
 			fmt.Printf("Stats retrieved\r\n")
 		}
 
@@ -288,10 +503,95 @@ func RetrievePlayersStats() error {
 		//fmt.Println(dbStats)
 	}
 
+	err = FinishStatsBatch(batch)
+	if err != nil {
+		fmt.Printf("Error finishing stats batch: %s\r\n", err.Error())
+		return err
+	}
+
 	return nil
 }
 
+// RetrievePlayersAggregateStats stores the per activity totals of every character of the enabled members as a new
+// stats batch. Deleted characters are skipped, their stats are no longer available.
 func RetrievePlayersAggregateStats() error {
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	collectionCharacters := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
+	collectionStats := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("ActivityStats")
+
+	var dbPlayers []Player
+	err := collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		fmt.Printf("Error reading players: %s\r\n", err.Error())
+		return err
+	}
+
+	batch, err := StartStatsBatch(BatchActivityStats, len(dbPlayers))
+	if err != nil {
+		fmt.Printf("Error starting stats batch: %s\r\n", err.Error())
+		return err
+	}
+	fmt.Printf("BatchID: %d\r\n", batch.BatchID)
+
+	for cnt, player := range dbPlayers {
+		fmt.Printf("Player %d/%d - %s (%s)\r\n", cnt+1, len(dbPlayers), player.DisplayName, player.MembershipID)
+
+		var characters []Character
+		err = collectionCharacters.Find(bson.M{"MembershipID": player.MembershipID, "Enabled": true}).All(&characters)
+		if err != nil {
+			fmt.Printf("Error reading characters: %s\r\n", err.Error())
+			batch.Failed++
+			continue
+		}
+
+		failed := false
+		for _, character := range characters {
+			stats, err := GetCharacterAggregateStats(character.MembershipType, character.MembershipID, character.CharacterID)
+			if err != nil {
+				fmt.Printf("Error retrieving stats for character %s: %s\r\n", character.CharacterID, err.Error())
+				failed = true
+				continue
+			}
+
+			characterStats := CharacterActivityStats{
+				BatchID:      batch.BatchID,
+				BatchTime:    time.Now(),
+				MembershipID: player.MembershipID,
+				MemberName:   player.DisplayName,
+				CharacterID:  character.CharacterID,
+				Activities:   []ActivityTotals{},
+			}
+			for _, activity := range stats.Activities {
+				characterStats.Activities = append(characterStats.Activities, ActivityTotals{
+					ActivityHash:        activity.ActivityHash,
+					Completions:         activity.Values.ActivityCompletions.Basic.Value,
+					Kills:               activity.Values.ActivityKills.Basic.Value,
+					Deaths:              activity.Values.ActivityDeaths.Basic.Value,
+					FastestCompletionMs: activity.Values.FastestCompletionMsForActivity.Basic.Value,
+					SecondsPlayed:       activity.Values.ActivitySecondsPlayed.Basic.Value,
+				})
+			}
+
+			err = collectionStats.Insert(characterStats)
+			if err != nil {
+				fmt.Printf("Error inserting stats: %s\r\n", err.Error())
+				failed = true
+			}
+		}
+
+		if failed {
+			batch.Failed++
+		} else {
+			batch.Retrieved++
+		}
+	}
+
+	err = FinishStatsBatch(batch)
+	if err != nil {
+		fmt.Printf("Error finishing stats batch: %s\r\n", err.Error())
+		return err
+	}
+
 	return nil
 }
 
@@ -315,6 +615,62 @@ func ContainsCharacter(baselist []Character, characterID string) bool {
 	return false
 }
 
+func ContainsString(baselist []string, value string) bool {
+	for _, item := range baselist {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeletedCharacterIDs returns the IDs of all characters that have been marked as deleted
+func DeletedCharacterIDs() ([]string, error) {
+	c := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
+
+	var characters []Character
+	err := c.Find(bson.M{"Deleted": true}).All(&characters)
+	if err != nil {
+		return nil, err
+	}
+
+	characterIDs := []string{}
+	for _, character := range characters {
+		characterIDs = append(characterIDs, character.CharacterID)
+	}
+
+	return characterIDs, nil
+}
+
+// MemberEntryQuery returns a query matching activities in which a member took part, ignoring entries for any of
+// the excluded characters
+func MemberEntryQuery(membershipID string, excludedCharacters []string) bson.M {
+	if len(excludedCharacters) == 0 {
+		return bson.M{"Entries.Player.DestinyUserInfo.MembershipID": membershipID}
+	}
+
+	return bson.M{"Entries": bson.M{"$elemMatch": bson.M{
+		"Player.DestinyUserInfo.MembershipID": membershipID,
+		"CharacterID":                         bson.M{"$nin": excludedCharacters},
+	}}}
+}
+
+// DropCharacterEntries removes the entries of any of the excluded characters from an activity
+func DropCharacterEntries(activity *PGCR, excludedCharacters []string) {
+	if len(excludedCharacters) == 0 {
+		return
+	}
+
+	entries := activity.Entries[:0]
+	for _, entry := range activity.Entries {
+		if !ContainsString(excludedCharacters, entry.CharacterID) {
+			entries = append(entries, entry)
+		}
+	}
+	activity.Entries = entries
+}
+
 func FixActivities() {
 	activitiesCollection := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
 
@@ -348,6 +704,65 @@ func FixActivities() {
 	}
 }
 
+// ResolveStoredCharacterTypes sets the class, race and gender of the entries of all stored activities using the loaded
+// manifest. This covers activities stored before the types were resolved at retrieval, and entries left with an
+// unknown class, race or gender because no manifest was loaded when they were retrieved.
+func ResolveStoredCharacterTypes() error {
+	if definitions == nil {
+		return fmt.Errorf("the manifest must be loaded to resolve character types")
+	}
+
+	activitiesCollection := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+	query := bson.M{"Entries": bson.M{"$elemMatch": bson.M{"$or": []bson.M{
+		{"Class": bson.M{"$exists": false}},
+		{"Class": ClassUnknown},
+		{"Race": RaceUnknown},
+		{"Gender": GenderUnknown},
+	}}}}
+
+	// Hashes missing from the manifest stay unknown, so the activities to resolve are listed up front rather than
+	// queried until none are left
+	var unresolved []PGCR
+	err := activitiesCollection.Find(query).Select(bson.M{"ActivityDetails.InstanceID": 1}).All(&unresolved)
+	if err != nil {
+		return err
+	}
+	total := len(unresolved)
+
+	resolved := 0
+	for start := 0; start < total; start += 100 {
+		end := start + 100
+		if end > total {
+			end = total
+		}
+		instanceIDs := []string{}
+		for _, activity := range unresolved[start:end] {
+			instanceIDs = append(instanceIDs, activity.ActivityDetails.InstanceID)
+		}
+
+		var activities []PGCR
+		err = activitiesCollection.Find(bson.M{"ActivityDetails.InstanceID": bson.M{"$in": instanceIDs}}).All(&activities)
+		if err != nil {
+			return err
+		}
+
+		for _, activity := range activities {
+			ResolveCharacterTypes(&activity)
+			err = activitiesCollection.Update(
+				bson.M{"ActivityDetails.InstanceID": activity.ActivityDetails.InstanceID},
+				bson.M{"$set": bson.M{"Entries": activity.Entries}},
+			)
+			if err != nil {
+				return err
+			}
+			resolved++
+		}
+		fmt.Printf("Resolved character types of %d of %d activities\r\n", resolved, total)
+	}
+
+	return nil
+}
+
 func FixActivity(InstanceID string) error {
 	activitiesCollection := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
 
@@ -365,16 +780,76 @@ func FixActivity(InstanceID string) error {
 	return nil
 }
 
-func WhoPlaysWithWho(startDate time.Time, endDate time.Time, postfix string, duplicates bool) {
-	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.json", config.ClanID, postfix))
+// CoPlayMode determines when two members are considered to have played together
+type CoPlayMode int
+
+const (
+	// CoPlayInstance links members that took part in the same activity instance
+	CoPlayInstance CoPlayMode = iota
+	// CoPlayFireteam links members that were in the same fireteam, with members that only shared the instance linked
+	// by a separate edge type
+	CoPlayFireteam
+)
+
+// EdgeWeight determines the value of the links between members
+type EdgeWeight int
+
+const (
+	// WeightActivities weighs links by the number of activities played together
+	WeightActivities EdgeWeight = iota
+	// WeightTimePlayed weighs links by the hours both members were in the activity at the same time
+	WeightTimePlayed
+)
+
+// CoPlay holds the co-play counts and overlapping time played for every pair of players
+type CoPlay struct {
+	// Instance counts the activities both players took part in
+	Instance [][]int
+	// Fireteam counts the activities in which both players were in the same fireteam
+	Fireteam [][]int
+	// InstanceSeconds holds the seconds both players were in the same activity at the same time
+	InstanceSeconds [][]float64
+	// FireteamSeconds holds the seconds both players were in the same fireteam at the same time
+	FireteamSeconds [][]float64
+}
+
+// Weights returns the link values for the given edge weight, for instance and fireteam co-play respectively
+func (coPlay CoPlay) Weights(weight EdgeWeight) ([][]float64, [][]float64) {
+	instance := make([][]float64, len(coPlay.Instance))
+	fireteam := make([][]float64, len(coPlay.Fireteam))
+	for i1 := range coPlay.Instance {
+		instance[i1] = make([]float64, len(coPlay.Instance))
+		fireteam[i1] = make([]float64, len(coPlay.Fireteam))
+		for i2 := range coPlay.Instance {
+			if weight == WeightTimePlayed {
+				instance[i1][i2] = math.Round(coPlay.InstanceSeconds[i1][i2]/36) / 100
+				fireteam[i1][i2] = math.Round(coPlay.FireteamSeconds[i1][i2]/36) / 100
+			} else {
+				instance[i1][i2] = float64(coPlay.Instance[i1][i2])
+				fireteam[i1][i2] = float64(coPlay.Fireteam[i1][i2])
+			}
+		}
+	}
+
+	return instance, fireteam
+}
+
+func WhoPlaysWithWho(startDate time.Time, endDate time.Time, postfix string, duplicates bool, includeDeleted bool, categories []string, coPlayMode CoPlayMode, weight EdgeWeight, completedOnly bool, format string) {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		fmt.Printf("Error reading categories: %s\r\n", err.Error())
+		return
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.%s", config.ClanID, postfix, GraphExtension(format)))
 	if err != nil {
 		fmt.Printf("Error opening file: %s", err.Error())
 		return
 	}
+	defer f.Close()
 
 	// First get a list of all members in db
 	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
-	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
 	var dbPlayers []Player
 	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
 	if err != nil {
@@ -382,50 +857,280 @@ func WhoPlaysWithWho(startDate time.Time, endDate time.Time, postfix string, dup
 		return
 	}
 
-	f.WriteString("{\r\n\t\"nodes\": [\r\n")
-	for _, player := range dbPlayers {
-		f.WriteString(fmt.Sprintf("\t\t{\"id\": \"%s\", \"group\": 1},\r\n", player.DisplayName))
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			fmt.Printf("Error reading deleted characters: %s\r\n", err.Error())
+			return
+		}
+	}
+
+	classes, err := MemberClasses()
+	if err != nil {
+		fmt.Printf("Error reading characters: %s\r\n", err.Error())
+		return
 	}
-	f.WriteString("\t],\r\n\t\"links\": [\r\n")
 
-	cnt := 0
+	coPlay, err := CoPlayMatrix(dbPlayers, ActivityQuery(startDate, endDate, modes), excludedCharacters, completedOnly)
+	if err != nil {
+		fmt.Printf("Error reading activities: %s\r\n", err.Error())
+		return
+	}
+
+	graph := BuildCoPlayGraph(dbPlayers, coPlay, classes, endDate, duplicates, coPlayMode, weight)
+
+	// Analyse the graph, which also adds the results to the exported nodes, and store the results of this run
+	analysis := GraphAnalysis{
+		RunTime:    time.Now(),
+		StartDate:  startDate,
+		EndDate:    endDate,
+		Categories: categories,
+		CoPlayMode: coPlayMode,
+		Weight:     weight,
+		Members:    AnalyseGraph(&graph),
+	}
+	for _, member := range analysis.Members {
+		if member.Community > analysis.Communities {
+			analysis.Communities = member.Community
+		}
+		if member.Isolated {
+			fmt.Printf("Isolated member: %s\r\n", member.DisplayName)
+		}
+	}
+	collectionAnalyses := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("GraphAnalyses")
+	err = collectionAnalyses.Insert(analysis)
+	if err != nil {
+		fmt.Printf("Error inserting graph analysis: %s\r\n", err.Error())
+	}
+	names := map[string]string{}
+	for _, node := range graph.Nodes {
+		names[node.ID] = node.Name
+	}
+	for _, link := range graph.Links {
+		if link.Type == "" {
+			fmt.Printf("%s,%s,%s\r\n", names[link.Source], names[link.Target], formatWeight(link.Value))
+		} else {
+			fmt.Printf("%s,%s,%s,%s\r\n", names[link.Source], names[link.Target], link.Type, formatWeight(link.Value))
+		}
+	}
+
+	err = WriteGraph(f, graph, format)
+	if err != nil {
+		fmt.Printf("Error writing graph: %s\r\n", err.Error())
+	}
+}
+
+// MemberClasses returns the class of the most recently played character of every member
+func MemberClasses() (map[string]DestinyClass, error) {
+	c := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
+
+	var characters []Character
+	err := c.Find(bson.M{"Enabled": true}).Sort("DateLastPlayed").All(&characters)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := map[string]DestinyClass{}
+	for _, character := range characters {
+		classes[character.MembershipID] = character.Class
+	}
+
+	return classes, nil
+}
+
+// CoPlayRanking writes every pair of members ranked by the hours they played together, counting the shared fireteam
+// time only when the fireteam co-play mode is used
+func CoPlayRanking(startDate time.Time, endDate time.Time, postfix string, includeDeleted bool, categories []string, coPlayMode CoPlayMode, completedOnly bool) {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		fmt.Printf("Error reading categories: %s\r\n", err.Error())
+		return
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		fmt.Printf("Error opening file: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	var dbPlayers []Player
+	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		fmt.Printf("Error reading members: %s\r\n", err.Error())
+		return
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			fmt.Printf("Error reading deleted characters: %s\r\n", err.Error())
+			return
+		}
+	}
+
+	coPlay, err := CoPlayMatrix(dbPlayers, ActivityQuery(startDate, endDate, modes), excludedCharacters, completedOnly)
+	if err != nil {
+		fmt.Printf("Error reading activities: %s\r\n", err.Error())
+		return
+	}
+
+	counts, seconds := coPlay.Instance, coPlay.InstanceSeconds
+	if coPlayMode == CoPlayFireteam {
+		counts, seconds = coPlay.Fireteam, coPlay.FireteamSeconds
+	}
+
+	type pair struct {
+		i1, i2 int
+	}
+	pairs := []pair{}
 	for i1 := 0; i1 < len(dbPlayers); i1++ {
-		startPos := i1 + 1
-		if duplicates {
-			startPos = 0
-		}
-		for i2 := startPos; i2 < len(dbPlayers); i2++ {
-			// db.Activities.find({"Entries.Player.DestinyUserInfo.MembershipID": {$all: [dbPlayers[i1].MembershipID,dbPlayers[i2].MembershipID]}})
-			cnt, _ = collectionActivities.Find(
-				bson.M{
-					"Entries.Player.DestinyUserInfo.MembershipID": bson.M{
-						"$all": []interface{}{
-							dbPlayers[i1].MembershipID,
-							dbPlayers[i2].MembershipID,
-						},
-					},
-					"Period": bson.M{
-						"$gt": startDate,
-						"$lt": endDate,
-					},
-				},
-			).Count()
-			if cnt > 0 {
-				fmt.Printf("%s,%s,%d\r\n", dbPlayers[i1].DisplayName, dbPlayers[i2].DisplayName, cnt)
-				f.WriteString(fmt.Sprintf("\t\t{\"source\": \"%s\", \"target\": \"%s\", \"value\": %d},\r\n", dbPlayers[i1].DisplayName, dbPlayers[i2].DisplayName, cnt))
+		for i2 := i1 + 1; i2 < len(dbPlayers); i2++ {
+			if counts[i1][i2] > 0 {
+				pairs = append(pairs, pair{i1, i2})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return seconds[pairs[i].i1][pairs[i].i2] > seconds[pairs[j].i1][pairs[j].i2]
+	})
+
+	f.WriteString("player1\tplayer2\thours\tactivities\r\n")
+	for _, p := range pairs {
+		f.WriteString(fmt.Sprintf("%s\t%s\t%.2f\t%d\r\n", dbPlayers[p.i1].DisplayName, dbPlayers[p.i2].DisplayName, seconds[p.i1][p.i2]/3600, counts[p.i1][p.i2]))
+	}
+}
+
+// CoPlayMatrix counts for every pair of players the activities matching the query in which both took part, and in
+// which both were in the same fireteam, with the diagonal holding the number of activities of each player. The
+// activities are scanned once, so the cost grows with the number of activities rather than with the number of pairs.
+// Entries for excluded characters are ignored. Entries without a fireteam ID are never counted as sharing a fireteam.
+// The overlapping time of two players is taken from when each joined the activity until they left it. With
+// completedOnly set, only entries of players that completed the activity are counted.
+func CoPlayMatrix(players []Player, query bson.M, excludedCharacters []string, completedOnly bool) (CoPlay, error) {
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+
+	memberIDs := []string{}
+	memberIndex := map[string]int{}
+	for i, player := range players {
+		memberIDs = append(memberIDs, player.MembershipID)
+		memberIndex[player.MembershipID] = i
+	}
+
+	coPlay := CoPlay{
+		Instance:        make([][]int, len(players)),
+		Fireteam:        make([][]int, len(players)),
+		InstanceSeconds: make([][]float64, len(players)),
+		FireteamSeconds: make([][]float64, len(players)),
+	}
+	for i := range players {
+		coPlay.Instance[i] = make([]int, len(players))
+		coPlay.Fireteam[i] = make([]int, len(players))
+		coPlay.InstanceSeconds[i] = make([]float64, len(players))
+		coPlay.FireteamSeconds[i] = make([]float64, len(players))
+	}
+
+	activityQuery := bson.M{"Entries.Player.DestinyUserInfo.MembershipID": bson.M{"$in": memberIDs}}
+	for key, value := range query {
+		activityQuery[key] = value
+	}
+
+	var activity PGCR
+	iter := collectionActivities.Find(activityQuery).Select(bson.M{
+		"Entries.Player.DestinyUserInfo.MembershipID": 1,
+		"Entries.CharacterID":                         1,
+		"Entries.Values.FireteamID":                   1,
+		"Entries.Values.Completed":                    1,
+		"Entries.Values.StartSeconds":                 1,
+		"Entries.Values.TimePlayedSeconds":            1,
+	}).Iter()
+	for iter.Next(&activity) {
+		present := []int{}
+		fireteams := map[int]string{}
+		starts := map[int]float64{}
+		ends := map[int]float64{}
+		for _, entry := range activity.Entries {
+			i, ok := memberIndex[entry.Player.DestinyUserInfo.MembershipID]
+			if !ok || ContainsString(excludedCharacters, entry.CharacterID) || ContainsIndex(present, i) {
+				continue
+			}
+			if completedOnly && entry.Values.Completed.Basic.Value != 1 {
+				continue
+			}
+			present = append(present, i)
+			fireteams[i] = FireteamID(entry.Values.FireteamID.Basic)
+			starts[i] = entry.Values.StartSeconds.Basic.Value
+			ends[i] = entry.Values.StartSeconds.Basic.Value + entry.Values.TimePlayedSeconds.Basic.Value
+		}
+
+		for _, i1 := range present {
+			for _, i2 := range present {
+				overlap := math.Max(0, math.Min(ends[i1], ends[i2])-math.Max(starts[i1], starts[i2]))
+				coPlay.Instance[i1][i2]++
+				coPlay.InstanceSeconds[i1][i2] += overlap
+				if fireteams[i1] != "" && fireteams[i1] == fireteams[i2] {
+					coPlay.Fireteam[i1][i2]++
+					coPlay.FireteamSeconds[i1][i2] += overlap
+				}
 			}
 		}
+		activity = PGCR{}
+	}
+
+	return coPlay, iter.Close()
+}
+
+func ContainsIndex(baselist []int, index int) bool {
+	for _, item := range baselist {
+		if item == index {
+			return true
+		}
 	}
 
-	f.WriteString("\t]\r\n}")
+	return false
 }
 
-func WhoPlaysWhen(startDate time.Time, endDate time.Time, postfix string) {
+// WhoPlaysWhen writes the minutes every member played in each time slot of each weekday, with slots of 15, 30 or 60
+// minutes. With localTime set, every member's activities are bucketed in their own timezone, otherwise in the reference
+// zone, which defaults to the clan timezone.
+func WhoPlaysWhen(startDate time.Time, endDate time.Time, postfix string, includeDeleted bool, categories []string, localTime bool, referenceZone string, resolution int) {
+	if resolution == 0 {
+		resolution = 60
+	}
+	if resolution != 15 && resolution != 30 && resolution != 60 {
+		fmt.Printf("Error reading resolution: unsupported resolution of %d minutes\r\n", resolution)
+		return
+	}
+
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		fmt.Printf("Error reading categories: %s\r\n", err.Error())
+		return
+	}
+
+	timezones, err := LoadTimezones()
+	if err != nil {
+		fmt.Printf("Error reading timezones: %s\r\n", err.Error())
+		return
+	}
+	reference := timezones.Default
+	if referenceZone != "" {
+		reference, err = time.LoadLocation(referenceZone)
+		if err != nil {
+			fmt.Printf("Error reading timezones: %s\r\n", err.Error())
+			return
+		}
+	}
+
 	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
 	if err != nil {
 		fmt.Printf("Error opening file: %s", err.Error())
 		return
 	}
+	defer f.Close()
 
 	// First get a list of all members in db
 	c1 := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
@@ -437,47 +1142,232 @@ func WhoPlaysWhen(startDate time.Time, endDate time.Time, postfix string) {
 		return
 	}
 
-	f.WriteString("player\thour\tvalue\r\n")
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			fmt.Printf("Error reading deleted characters: %s\r\n", err.Error())
+			return
+		}
+	}
+
+	f.WriteString("player\ttimezone\tweekday\ttime\tvalue\r\n")
 
 	for _, player := range dbPlayers {
 		if !player.Enabled {
 			continue
 		}
+		location := reference
+		if localTime {
+			location = timezones.Location(player)
+		}
+
 		var dbActivities []PGCR
-		//timeSlots := []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-		var timeSlots [24]int
-		c2.Find(
-			bson.M{
-				"Entries.Player.DestinyUserInfo.MembershipID": player.MembershipID,
-				"Period": bson.M{
-					"$gt": startDate,
-					"$lt": endDate,
-				},
-			},
-		).All(&dbActivities)
-		timezone, _ := time.LoadLocation("Europe/London")
+		var timeSlots [7][]float64
+		for weekday := range timeSlots {
+			timeSlots[weekday] = make([]float64, 24*60/resolution)
+		}
+		query := ActivityQuery(startDate, endDate, modes)
+		for key, value := range MemberEntryQuery(player.MembershipID, excludedCharacters) {
+			query[key] = value
+		}
+		err = c2.Find(query).All(&dbActivities)
+		if err != nil {
+			fmt.Printf("Error reading activities: %s\r\n", err.Error())
+			return
+		}
 
 		for i, activity := range dbActivities {
 			fmt.Printf("Activity %d of %d for %s\r\n", i+1, len(dbActivities), player.DisplayName)
-			duration := 0
 			for _, participant := range activity.Entries {
-				if participant.Player.DestinyUserInfo.MembershipID == player.MembershipID {
-					duration = int(participant.Values.TimePlayedSeconds.Basic.Value) / 60
+				if participant.Player.DestinyUserInfo.MembershipID != player.MembershipID || ContainsString(excludedCharacters, participant.CharacterID) {
+					continue
 				}
+
+				// Players joining an activity in progress only start playing StartSeconds after the activity began
+				start := activity.Period.Add(time.Duration(participant.Values.StartSeconds.Basic.Value) * time.Second).In(location)
+				end := start.Add(time.Duration(participant.Values.TimePlayedSeconds.Basic.Value) * time.Second)
+				SpreadPlaytime(&timeSlots, start, end, resolution)
 			}
-			for slot := activity.Period.In(timezone).Hour(); slot < (activity.Period.In(timezone).Hour() + duration); slot++ {
-				timeSlots[slot%24]++
+		}
+
+		for weekday, slots := range timeSlots {
+			for i, minutes := range slots {
+				f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%02d:%02d\t%s\r\n", player.DisplayName, location.String(), time.Weekday(weekday), i*resolution/60, i*resolution%60, formatWeight(math.Round(minutes*100)/100)))
 			}
 		}
+	}
+}
+
+// SpreadPlaytime adds the minutes between start and end to the weekday time slots they fall in, splitting the time
+// proportionally over every slot the period overlaps. The slots are taken in the timezone of start.
+func SpreadPlaytime(timeSlots *[7][]float64, start time.Time, end time.Time, resolution int) {
+	ForEachSlot(start, end, resolution, func(t time.Time, minutes float64) {
+		timeSlots[t.Weekday()][(t.Hour()*60+t.Minute())/resolution] += minutes
+	})
+}
 
-		for i, slot := range timeSlots {
-			//if slot > 0 {
-			f.WriteString(fmt.Sprintf("%s\t%d\t%d\r\n", player.DisplayName, i, slot))
-			//}
+// ForEachSlot splits the period between start and end at every slot boundary, and calls fn with the start and length
+// in minutes of each part
+func ForEachSlot(start time.Time, end time.Time, resolution int, fn func(t time.Time, minutes float64)) {
+	slotLength := time.Duration(resolution) * time.Minute
+	for t := start; t.Before(end); {
+		slotStart := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()/resolution*resolution, 0, 0, t.Location())
+		next := slotStart.Add(slotLength)
+		if !next.After(t) {
+			// Guards against clock changes moving the slot start
+			next = t.Add(slotLength)
+		}
+		if next.After(end) {
+			next = end
 		}
+
+		fn(t, next.Sub(t).Minutes())
+		t = next
 	}
 }
 
+type WeaponUsage struct {
+	ReferenceID    int64
+	Name           string
+	ItemType       string
+	TierType       string
+	Kills          float64
+	PrecisionKills float64
+	Activities     int
+}
+
+// MostUsedWeapons writes the weapons used by each member and by the clan as a whole, ranked by kills, for activities in
+// the given period. Activities can be limited to a set of activity categories or modes, an empty list includes all.
+// Weapons used on deleted characters only count when those are included.
+func MostUsedWeapons(startDate time.Time, endDate time.Time, postfix string, categories []string, includeDeleted bool) {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		fmt.Printf("Error reading categories: %s\r\n", err.Error())
+		return
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		fmt.Printf("Error opening file: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+	var dbPlayers []Player
+	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		fmt.Printf("Error reading members: %s\r\n", err.Error())
+		return
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			fmt.Printf("Error reading deleted characters: %s\r\n", err.Error())
+			return
+		}
+	}
+
+	memberIDs := []string{}
+	memberWeapons := map[string]map[int64]*WeaponUsage{}
+	for _, player := range dbPlayers {
+		memberIDs = append(memberIDs, player.MembershipID)
+		memberWeapons[player.MembershipID] = map[int64]*WeaponUsage{}
+	}
+	clanWeapons := map[int64]*WeaponUsage{}
+
+	query := ActivityQuery(startDate, endDate, modes)
+	query["Entries.Player.DestinyUserInfo.MembershipID"] = bson.M{"$in": memberIDs}
+
+	var activity PGCR
+	iter := collectionActivities.Find(query).Iter()
+	for iter.Next(&activity) {
+		DropCharacterEntries(&activity, excludedCharacters)
+		for _, entry := range activity.Entries {
+			weapons, ok := memberWeapons[entry.Player.DestinyUserInfo.MembershipID]
+			if !ok {
+				continue
+			}
+			for _, weapon := range entry.Extended.Weapons {
+				for _, usage := range []map[int64]*WeaponUsage{weapons, clanWeapons} {
+					if _, ok := usage[weapon.ReferenceID]; !ok {
+						usage[weapon.ReferenceID] = NewWeaponUsage(weapon.ReferenceID)
+					}
+					usage[weapon.ReferenceID].Kills += weapon.Values.UniqueWeaponKills.Basic.Value
+					usage[weapon.ReferenceID].PrecisionKills += weapon.Values.UniqueWeaponPrecisionKills.Basic.Value
+					usage[weapon.ReferenceID].Activities++
+				}
+			}
+		}
+		activity = PGCR{}
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("Error reading activities: %s\r\n", err.Error())
+		return
+	}
+
+	f.WriteString("player\tweapon\ttype\ttier\tkills\tprecisionkills\tactivities\r\n")
+	for _, player := range dbPlayers {
+		for _, usage := range RankWeapons(memberWeapons[player.MembershipID]) {
+			f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%.0f\t%.0f\t%d\r\n", player.DisplayName, usage.Name, usage.ItemType, usage.TierType, usage.Kills, usage.PrecisionKills, usage.Activities))
+		}
+	}
+	for _, usage := range RankWeapons(clanWeapons) {
+		f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%.0f\t%.0f\t%d\r\n", "Clan", usage.Name, usage.ItemType, usage.TierType, usage.Kills, usage.PrecisionKills, usage.Activities))
+	}
+}
+
+// NewWeaponUsage returns an empty WeaponUsage for a weapon, resolving its name, type and tier from the manifest
+func NewWeaponUsage(referenceID int64) *WeaponUsage {
+	usage := &WeaponUsage{
+		ReferenceID: referenceID,
+		Name:        ItemName(referenceID),
+	}
+	if definitions != nil {
+		if item, ok := definitions.Items[referenceID]; ok {
+			usage.ItemType = item.ItemTypeDisplayName
+			usage.TierType = item.Inventory.TierTypeName
+		}
+	}
+
+	return usage
+}
+
+// RankWeapons returns the weapon usages ordered by kills, most kills first
+func RankWeapons(usages map[int64]*WeaponUsage) []*WeaponUsage {
+	ranked := []*WeaponUsage{}
+	for _, usage := range usages {
+		ranked = append(ranked, usage)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Kills == ranked[j].Kills {
+			return ranked[i].ReferenceID < ranked[j].ReferenceID
+		}
+		return ranked[i].Kills > ranked[j].Kills
+	})
+
+	return ranked
+}
+
+// ActivityQuery returns a query matching activities in the given period, limited to the given activity modes if any
+func ActivityQuery(startDate time.Time, endDate time.Time, modes []DestinyActivityModeType) bson.M {
+	query := bson.M{
+		"Period": bson.M{
+			"$gt": startDate,
+			"$lt": endDate,
+		},
+	}
+	if len(modes) > 0 {
+		query["ActivityDetails.Modes"] = bson.M{"$in": modes}
+	}
+
+	return query
+}
+
 func TestActivity(InstanceID string) {
 	c := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
 	var activity PGCR
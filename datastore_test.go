@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type slotMinutes struct {
+	weekday time.Weekday
+	slot    int
+	minutes float64
+}
+
+func TestSpreadPlaytime(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      time.Time
+		end        time.Time
+		resolution int
+		want       []slotMinutes
+	}{
+		{
+			name:       "within one slot",
+			start:      time.Date(2019, time.June, 3, 10, 5, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 10, 25, 0, 0, time.UTC),
+			resolution: 30,
+			want:       []slotMinutes{{time.Monday, 20, 20}},
+		},
+		{
+			name:       "split over a slot boundary",
+			start:      time.Date(2019, time.June, 3, 10, 10, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 10, 50, 0, 0, time.UTC),
+			resolution: 30,
+			want:       []slotMinutes{{time.Monday, 20, 20}, {time.Monday, 21, 20}},
+		},
+		{
+			name:       "quarter hours",
+			start:      time.Date(2019, time.June, 3, 10, 0, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 10, 45, 0, 0, time.UTC),
+			resolution: 15,
+			want:       []slotMinutes{{time.Monday, 40, 15}, {time.Monday, 41, 15}, {time.Monday, 42, 15}},
+		},
+		{
+			name:       "over midnight",
+			start:      time.Date(2019, time.June, 8, 23, 45, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 9, 0, 15, 30, 0, time.UTC),
+			resolution: 60,
+			want:       []slotMinutes{{time.Saturday, 23, 15}, {time.Sunday, 0, 15.5}},
+		},
+		{
+			name:       "empty period",
+			start:      time.Date(2019, time.June, 3, 10, 0, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 10, 0, 0, 0, time.UTC),
+			resolution: 30,
+			want:       []slotMinutes{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var timeSlots [7][]float64
+			for i := range timeSlots {
+				timeSlots[i] = make([]float64, 24*60/test.resolution)
+			}
+			SpreadPlaytime(&timeSlots, test.start, test.end, test.resolution)
+
+			var want [7][]float64
+			for i := range want {
+				want[i] = make([]float64, 24*60/test.resolution)
+			}
+			for _, expected := range test.want {
+				want[expected.weekday][expected.slot] = expected.minutes
+			}
+
+			for weekday := range timeSlots {
+				for slot, minutes := range timeSlots[weekday] {
+					if math.Abs(minutes-want[weekday][slot]) > 1e-9 {
+						t.Errorf("slot %d of %s = %v, want %v", slot, time.Weekday(weekday), minutes, want[weekday][slot])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestForEachSlot(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %s", err.Error())
+	}
+
+	type part struct {
+		start   time.Time
+		minutes float64
+	}
+	tests := []struct {
+		name       string
+		start      time.Time
+		end        time.Time
+		resolution int
+		want       []part
+	}{
+		{
+			name:       "split at every boundary",
+			start:      time.Date(2019, time.June, 3, 10, 20, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 11, 10, 0, 0, time.UTC),
+			resolution: 30,
+			want: []part{
+				{time.Date(2019, time.June, 3, 10, 20, 0, 0, time.UTC), 10},
+				{time.Date(2019, time.June, 3, 10, 30, 0, 0, time.UTC), 30},
+				{time.Date(2019, time.June, 3, 11, 0, 0, 0, time.UTC), 10},
+			},
+		},
+		{
+			name:       "end before start",
+			start:      time.Date(2019, time.June, 3, 11, 0, 0, 0, time.UTC),
+			end:        time.Date(2019, time.June, 3, 10, 0, 0, 0, time.UTC),
+			resolution: 30,
+			want:       []part{},
+		},
+		{
+			name:       "over the start of daylight saving time",
+			start:      time.Date(2019, time.March, 31, 1, 30, 0, 0, amsterdam),
+			end:        time.Date(2019, time.March, 31, 3, 30, 0, 0, amsterdam),
+			resolution: 60,
+			want: []part{
+				{time.Date(2019, time.March, 31, 1, 30, 0, 0, amsterdam), 30},
+				{time.Date(2019, time.March, 31, 3, 0, 0, 0, amsterdam), 30},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []part{}
+			ForEachSlot(test.start, test.end, test.resolution, func(start time.Time, minutes float64) {
+				got = append(got, part{start, minutes})
+			})
+
+			if len(got) != len(test.want) {
+				t.Fatalf("ForEachSlot() gave %d parts, want %d: %v", len(got), len(test.want), got)
+			}
+			for i := range got {
+				if !got[i].start.Equal(test.want[i].start) || got[i].minutes != test.want[i].minutes {
+					t.Errorf("part %d = %v %v, want %v %v", i, got[i].start, got[i].minutes, test.want[i].start, test.want[i].minutes)
+				}
+			}
+		})
+	}
+}
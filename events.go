@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// EventWindow is a candidate time for a clan event, with the attendance it drew historically
+type EventWindow struct {
+	Weekday    time.Weekday
+	Start      int // Minutes after midnight
+	Duration   time.Duration
+	Weeks      int
+	Expected   float64
+	Confidence float64
+	Attendees  []EventAttendee
+}
+
+// EventAttendee is a member with the share of past weeks in which they were playing during an event window
+type EventAttendee struct {
+	DisplayName string
+	Probability float64
+}
+
+// GroupMembers returns the enabled members of a target group. The group is "clan" or empty for the whole clan, the name
+// of a clan role or of a tag from MemberTags, or a comma separated list of membership IDs and display names.
+func GroupMembers(group string) ([]Player, error) {
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	var dbPlayers []Player
+	err := collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		return nil, err
+	}
+
+	group = strings.TrimSpace(group)
+	if group == "" || strings.EqualFold(group, "clan") {
+		return dbPlayers, nil
+	}
+
+	if role, ok := LookupClanRole(group); ok {
+		players := []Player{}
+		for _, player := range dbPlayers {
+			if player.ClanRole == role {
+				players = append(players, player)
+			}
+		}
+		return players, nil
+	}
+
+	names := SplitList(group)
+	for tag, members := range config.MemberTags {
+		if strings.EqualFold(tag, group) {
+			names = members
+			break
+		}
+	}
+
+	players := []Player{}
+	for _, name := range names {
+		found := false
+		for _, player := range dbPlayers {
+			if player.MembershipID == name || strings.EqualFold(player.DisplayName, name) {
+				players = append(players, player)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown member %s", name)
+		}
+	}
+
+	return players, nil
+}
+
+// RecommendEventTimes ranks every possible event start time, on the allowed weekdays, by the number of group members
+// that were playing during the event in past weeks. A member counts as attending a week when they were present in at
+// least half of the event's time slots, not necessarily the whole event. The confidence is the share of past weeks in
+// which at least the minimum number of members attended. The top windows are printed and written to a TSV file.
+func RecommendEventTimes(startDate time.Time, endDate time.Time, postfix string, group string, duration time.Duration, weekdays []time.Weekday, top int, minimum int, categories []string, includeDeleted bool, referenceZone string, resolution int) ([]EventWindow, error) {
+	if resolution != 15 && resolution != 30 && resolution != 60 {
+		return nil, fmt.Errorf("unsupported resolution of %d minutes", resolution)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("event duration must be positive")
+	}
+	if minimum < 1 {
+		minimum = 1
+	}
+
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.UTC
+	if referenceZone != "" {
+		location, err = time.LoadLocation(referenceZone)
+	} else {
+		var timezones Timezones
+		timezones, err = LoadTimezones()
+		location = timezones.Default
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	players, err := GroupMembers(group)
+	if err != nil {
+		return nil, err
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Slots are counted from the start of the week containing the start date
+	startDate = startDate.In(location)
+	endDate = endDate.In(location)
+	origin := time.Date(startDate.Year(), startDate.Month(), startDate.Day()-int(startDate.Weekday()), 0, 0, 0, 0, location)
+	slotsPerDay := 24 * 60 / resolution
+	weeks := daysBetween(origin, endDate)/7 + 1
+	slotCount := weeks * 7 * slotsPerDay
+
+	presence, err := MemberPresence(players, startDate, endDate, modes, excludedCharacters, origin, resolution, slotCount)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(math.Ceil(duration.Minutes() / float64(resolution)))
+	needed := (length + 1) / 2
+	windows := []EventWindow{}
+	for day := 0; day < 7; day++ {
+		if len(weekdays) > 0 && !ContainsWeekday(weekdays, time.Weekday(day)) {
+			continue
+		}
+
+		for slot := 0; slot < slotsPerDay; slot++ {
+			window := EventWindow{Weekday: time.Weekday(day), Start: slot * resolution, Duration: duration}
+			attended := make([]int, len(players))
+			reached := 0
+
+			for week := 0; week < weeks; week++ {
+				windowStart := time.Date(origin.Year(), origin.Month(), origin.Day()+week*7+day, 0, window.Start, 0, 0, location)
+				if windowStart.Before(startDate) || windowStart.Add(duration).After(endDate) {
+					continue
+				}
+				window.Weeks++
+
+				first := (week*7+day)*slotsPerDay + slot
+				attendance := 0
+				for i := range players {
+					present := 0
+					for s := first; s < first+length && s < slotCount; s++ {
+						if presence[i][s] {
+							present++
+						}
+					}
+					if present >= needed {
+						attended[i]++
+						attendance++
+					}
+				}
+				window.Expected += float64(attendance)
+				if attendance >= minimum {
+					reached++
+				}
+			}
+			if window.Weeks == 0 {
+				continue
+			}
+
+			window.Expected = window.Expected / float64(window.Weeks)
+			window.Confidence = float64(reached) / float64(window.Weeks)
+			for i, player := range players {
+				probability := float64(attended[i]) / float64(window.Weeks)
+				if probability >= 0.5 {
+					window.Attendees = append(window.Attendees, EventAttendee{DisplayName: player.DisplayName, Probability: probability})
+				}
+			}
+			sort.SliceStable(window.Attendees, func(i, j int) bool {
+				return window.Attendees[i].Probability > window.Attendees[j].Probability
+			})
+			windows = append(windows, window)
+		}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		if windows[i].Expected != windows[j].Expected {
+			return windows[i].Expected > windows[j].Expected
+		}
+		return windows[i].Confidence > windows[j].Confidence
+	})
+	if top > 0 && len(windows) > top {
+		windows = windows[:top]
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return windows, err
+	}
+	defer f.Close()
+
+	f.WriteString("rank\tweekday\tstart\tend\ttimezone\texpected\tconfidence\tweeks\tattendees\r\n")
+	for i, window := range windows {
+		attendees := []string{}
+		for _, attendee := range window.Attendees {
+			attendees = append(attendees, fmt.Sprintf("%s (%.0f%%)", attendee.DisplayName, attendee.Probability*100))
+		}
+		end := window.Start + int(duration.Minutes())
+		line := fmt.Sprintf("%d\t%s\t%02d:%02d\t%02d:%02d\t%s\t%.2f\t%.0f%%\t%d\t%s",
+			i+1, window.Weekday, window.Start/60, window.Start%60, end/60%24, end%60, location.String(),
+			window.Expected, window.Confidence*100, window.Weeks, strings.Join(attendees, ", "))
+		fmt.Printf("%s\r\n", line)
+		f.WriteString(line + "\r\n")
+	}
+
+	return windows, nil
+}
+
+// MemberPresence returns for every player whether they were playing in each time slot since the origin. A player is
+// present in a slot when they played at least half of it.
+func MemberPresence(players []Player, startDate time.Time, endDate time.Time, modes []DestinyActivityModeType, excludedCharacters []string, origin time.Time, resolution int, slotCount int) ([][]bool, error) {
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+	slotsPerDay := 24 * 60 / resolution
+
+	presence := make([][]bool, len(players))
+	for i, player := range players {
+		minutes := make([]float64, slotCount)
+		presence[i] = make([]bool, slotCount)
+
+		query := ActivityQuery(startDate, endDate, modes)
+		for key, value := range MemberEntryQuery(player.MembershipID, excludedCharacters) {
+			query[key] = value
+		}
+		var dbActivities []PGCR
+		err := collectionActivities.Find(query).Select(bson.M{"Period": 1, "Entries": 1}).All(&dbActivities)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, activity := range dbActivities {
+			for _, participant := range activity.Entries {
+				if participant.Player.DestinyUserInfo.MembershipID != player.MembershipID || ContainsString(excludedCharacters, participant.CharacterID) {
+					continue
+				}
+
+				start := activity.Period.Add(time.Duration(participant.Values.StartSeconds.Basic.Value) * time.Second).In(origin.Location())
+				end := start.Add(time.Duration(participant.Values.TimePlayedSeconds.Basic.Value) * time.Second)
+				ForEachSlot(start, end, resolution, func(t time.Time, played float64) {
+					slot := daysBetween(origin, t)*slotsPerDay + (t.Hour()*60+t.Minute())/resolution
+					if slot >= 0 && slot < slotCount {
+						minutes[slot] += played
+					}
+				})
+			}
+		}
+
+		for slot, played := range minutes {
+			presence[i][slot] = played >= float64(resolution)/2
+		}
+	}
+
+	return presence, nil
+}
+
+// daysBetween returns the number of calendar days from the date of origin to the date of t, in the timezone of origin
+func daysBetween(origin time.Time, t time.Time) int {
+	t = t.In(origin.Location())
+	from := time.Date(origin.Year(), origin.Month(), origin.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
+
+// ParseWeekdays parses a comma separated list of weekday names, which may be abbreviated to their first three letters
+func ParseWeekdays(list string) ([]time.Weekday, error) {
+	weekdays := []time.Weekday{}
+	for _, name := range SplitList(list) {
+		found := false
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(day.String(), name) || (len(name) >= 3 && strings.HasPrefix(strings.ToLower(day.String()), strings.ToLower(name))) {
+				weekdays = append(weekdays, day)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown weekday %s", name)
+		}
+	}
+
+	return weekdays, nil
+}
+
+func ContainsWeekday(baselist []time.Weekday, weekday time.Weekday) bool {
+	for _, item := range baselist {
+		if item == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SplitList splits a comma separated list, dropping empty entries
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDaysBetween(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone database unavailable: %s", err.Error())
+	}
+
+	tests := []struct {
+		name   string
+		origin time.Time
+		t      time.Time
+		want   int
+	}{
+		{
+			name:   "same day",
+			origin: time.Date(2019, time.June, 3, 0, 0, 0, 0, time.UTC),
+			t:      time.Date(2019, time.June, 3, 23, 59, 0, 0, time.UTC),
+			want:   0,
+		},
+		{
+			name:   "next day within 24 hours",
+			origin: time.Date(2019, time.June, 3, 23, 0, 0, 0, time.UTC),
+			t:      time.Date(2019, time.June, 4, 1, 0, 0, 0, time.UTC),
+			want:   1,
+		},
+		{
+			name:   "before origin",
+			origin: time.Date(2019, time.June, 3, 12, 0, 0, 0, time.UTC),
+			t:      time.Date(2019, time.May, 31, 12, 0, 0, 0, time.UTC),
+			want:   -3,
+		},
+		{
+			name:   "in the timezone of origin",
+			origin: time.Date(2019, time.June, 3, 12, 0, 0, 0, newYork),
+			t:      time.Date(2019, time.June, 4, 2, 0, 0, 0, time.UTC),
+			want:   0,
+		},
+		{
+			name:   "over a daylight saving time change",
+			origin: time.Date(2019, time.March, 9, 0, 0, 0, 0, newYork),
+			t:      time.Date(2019, time.March, 11, 0, 0, 0, 0, newYork),
+			want:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := daysBetween(test.origin, test.t); got != test.want {
+				t.Errorf("daysBetween() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []time.Weekday
+		wantErr bool
+	}{
+		{list: "", want: []time.Weekday{}},
+		{list: "Friday,saturday", want: []time.Weekday{time.Friday, time.Saturday}},
+		{list: "mon, Wed ,thurs", want: []time.Weekday{time.Monday, time.Wednesday, time.Thursday}},
+		{list: "su", wantErr: true},
+		{list: "monday,funday", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			got, err := ParseWeekdays(test.list)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseWeekdays() error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseWeekdays() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported graph export formats
+const (
+	GraphJSON    = "json"
+	GraphGraphML = "graphml"
+	GraphGEXF    = "gexf"
+	GraphDOT     = "dot"
+)
+
+// Graph is the clan social graph, with a node per member and a link per pair of members that played together
+type Graph struct {
+	Directed bool        `json:"directed"`
+	Nodes    []GraphNode `json:"nodes"`
+	Links    []GraphLink `json:"links"`
+}
+
+type GraphNode struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Group       int     `json:"group"`
+	Class       string  `json:"class"`
+	Tenure      int     `json:"tenure"`
+	Activities  int     `json:"activities"`
+	Community   int     `json:"community"`
+	Degree      int     `json:"degree"`
+	Betweenness float64 `json:"betweenness"`
+	Eigenvector float64 `json:"eigenvector"`
+	Isolated    bool    `json:"isolated"`
+}
+
+type GraphLink struct {
+	Source string  `json:"source" bson:"Source"`
+	Target string  `json:"target" bson:"Target"`
+	Type   string  `json:"type,omitempty" bson:"Type,omitempty"`
+	Value  float64 `json:"value" bson:"Value"`
+}
+
+// graphAttribute is a single node attribute as written to the GraphML, GEXF and DOT exports
+type graphAttribute struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// BuildCoPlayGraph builds the social graph from the co-play between players. Tenure is the number of days a member had
+// been in the clan at the end date, and classes holds the most recently played class of each member. With duplicates
+// set, every pair is linked in both directions along with a link from each member to themselves.
+func BuildCoPlayGraph(players []Player, coPlay CoPlay, classes map[string]DestinyClass, endDate time.Time, duplicates bool, coPlayMode CoPlayMode, weight EdgeWeight) Graph {
+	graph := Graph{
+		Directed: duplicates,
+		Nodes:    []GraphNode{},
+		Links:    []GraphLink{},
+	}
+
+	for i, player := range players {
+		node := GraphNode{
+			ID:         player.MembershipID,
+			Name:       player.DisplayName,
+			Group:      1,
+			Activities: coPlay.Instance[i][i],
+		}
+		if class, ok := classes[player.MembershipID]; ok {
+			node.Class = class.String()
+		}
+		if !player.JoinDate.IsZero() && endDate.After(player.JoinDate) {
+			node.Tenure = int(endDate.Sub(player.JoinDate).Hours() / 24)
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	}
+
+	instance, fireteam := coPlay.Weights(weight)
+	for i1 := 0; i1 < len(players); i1++ {
+		startPos := i1 + 1
+		if duplicates {
+			startPos = 0
+		}
+		for i2 := startPos; i2 < len(players); i2++ {
+			if coPlayMode == CoPlayInstance {
+				if instance[i1][i2] > 0 {
+					graph.Links = append(graph.Links, GraphLink{
+						Source: players[i1].MembershipID,
+						Target: players[i2].MembershipID,
+						Value:  instance[i1][i2],
+					})
+				}
+				continue
+			}
+
+			if fireteam[i1][i2] > 0 {
+				graph.Links = append(graph.Links, GraphLink{
+					Source: players[i1].MembershipID,
+					Target: players[i2].MembershipID,
+					Type:   LinkFireteam,
+					Value:  fireteam[i1][i2],
+				})
+			}
+			if value := math.Round((instance[i1][i2]-fireteam[i1][i2])*100) / 100; value > 0 {
+				graph.Links = append(graph.Links, GraphLink{
+					Source: players[i1].MembershipID,
+					Target: players[i2].MembershipID,
+					Type:   LinkInstance,
+					Value:  value,
+				})
+			}
+		}
+	}
+
+	return graph
+}
+
+// Link types of graphs built in the fireteam co-play mode
+const (
+	LinkFireteam = "fireteam"
+	LinkInstance = "instance"
+)
+
+// GraphExtension returns the file extension for a graph export format
+func GraphExtension(format string) string {
+	if format == "" {
+		return GraphJSON
+	}
+
+	return format
+}
+
+// WriteGraph writes the graph in the given format
+func WriteGraph(w io.Writer, graph Graph, format string) error {
+	switch format {
+	case GraphJSON, "":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(graph)
+	case GraphGraphML:
+		return WriteGraphML(w, graph)
+	case GraphGEXF:
+		return WriteGEXF(w, graph)
+	case GraphDOT:
+		return WriteDOT(w, graph)
+	}
+
+	return fmt.Errorf("unknown graph format %s", format)
+}
+
+// nodeAttributes returns the attributes of a node, other than its ID, as written to the non JSON exports
+func nodeAttributes(node GraphNode) []graphAttribute {
+	return []graphAttribute{
+		{Name: "name", Type: "string", Value: node.Name},
+		{Name: "group", Type: "int", Value: strconv.Itoa(node.Group)},
+		{Name: "class", Type: "string", Value: node.Class},
+		{Name: "tenure", Type: "int", Value: strconv.Itoa(node.Tenure)},
+		{Name: "activities", Type: "int", Value: strconv.Itoa(node.Activities)},
+		{Name: "community", Type: "int", Value: strconv.Itoa(node.Community)},
+		{Name: "degree", Type: "int", Value: strconv.Itoa(node.Degree)},
+		{Name: "betweenness", Type: "double", Value: formatWeight(node.Betweenness)},
+		{Name: "eigenvector", Type: "double", Value: formatWeight(node.Eigenvector)},
+		{Name: "isolated", Type: "boolean", Value: strconv.FormatBool(node.Isolated)},
+	}
+}
+
+func formatWeight(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// WriteGraphML writes the graph as GraphML
+func WriteGraphML(w io.Writer, graph Graph) error {
+	doc := graphMLDocument{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Graph.ID = "G"
+	doc.Graph.EdgeDefault = "undirected"
+	if graph.Directed {
+		doc.Graph.EdgeDefault = "directed"
+	}
+
+	for _, attribute := range nodeAttributes(GraphNode{}) {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: attribute.Name, For: "node", AttrName: attribute.Name, AttrType: attribute.Type})
+	}
+	doc.Keys = append(doc.Keys,
+		graphMLKey{ID: "type", For: "edge", AttrName: "type", AttrType: "string"},
+		graphMLKey{ID: "weight", For: "edge", AttrName: "weight", AttrType: "double"},
+	)
+
+	for _, node := range graph.Nodes {
+		xmlNode := graphMLNode{ID: node.ID}
+		for _, attribute := range nodeAttributes(node) {
+			xmlNode.Data = append(xmlNode.Data, graphMLData{Key: attribute.Name, Value: attribute.Value})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, xmlNode)
+	}
+	for _, link := range graph.Links {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: link.Source,
+			Target: link.Target,
+			Data: []graphMLData{
+				{Key: "type", Value: link.Type},
+				{Key: "weight", Value: formatWeight(link.Value)},
+			},
+		})
+	}
+
+	return writeXML(w, doc)
+}
+
+type gexfDocument struct {
+	XMLName xml.Name `xml:"gexf"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		Mode            string `xml:"mode,attr"`
+		DefaultEdgeType string `xml:"defaultedgetype,attr"`
+		Attributes      struct {
+			Class      string          `xml:"class,attr"`
+			Attributes []gexfAttribute `xml:"attribute"`
+		} `xml:"attributes"`
+		Nodes []gexfNode `xml:"nodes>node"`
+		Edges []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string  `xml:"id,attr"`
+	Source string  `xml:"source,attr"`
+	Target string  `xml:"target,attr"`
+	Label  string  `xml:"label,attr,omitempty"`
+	Weight float64 `xml:"weight,attr"`
+}
+
+// WriteGEXF writes the graph as GEXF, as used by Gephi
+func WriteGEXF(w io.Writer, graph Graph) error {
+	doc := gexfDocument{Xmlns: "http://www.gexf.net/1.2draft", Version: "1.2"}
+	doc.Graph.Mode = "static"
+	doc.Graph.DefaultEdgeType = "undirected"
+	if graph.Directed {
+		doc.Graph.DefaultEdgeType = "directed"
+	}
+
+	doc.Graph.Attributes.Class = "node"
+	for _, attribute := range nodeAttributes(GraphNode{}) {
+		gexfType := attribute.Type
+		if gexfType == "int" {
+			gexfType = "integer"
+		}
+		doc.Graph.Attributes.Attributes = append(doc.Graph.Attributes.Attributes, gexfAttribute{ID: attribute.Name, Title: attribute.Name, Type: gexfType})
+	}
+
+	for _, node := range graph.Nodes {
+		gexfNode := gexfNode{ID: node.ID, Label: node.Name}
+		for _, attribute := range nodeAttributes(node) {
+			gexfNode.AttValues = append(gexfNode.AttValues, gexfAttValue{For: attribute.Name, Value: attribute.Value})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode)
+	}
+	for i, link := range graph.Links {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: link.Source,
+			Target: link.Target,
+			Label:  link.Type,
+			Weight: link.Value,
+		})
+	}
+
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language
+func WriteDOT(w io.Writer, graph Graph) error {
+	keyword, connector := "graph", "--"
+	if graph.Directed {
+		keyword, connector = "digraph", "->"
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%s G {\n", keyword))
+	for _, node := range graph.Nodes {
+		attributes := []string{fmt.Sprintf("label=%s", dotQuote(node.Name))}
+		for _, attribute := range nodeAttributes(node) {
+			attributes = append(attributes, fmt.Sprintf("%s=%s", attribute.Name, dotQuote(attribute.Value)))
+		}
+		b.WriteString(fmt.Sprintf("\t%s [%s];\n", dotQuote(node.ID), strings.Join(attributes, ", ")))
+	}
+	for _, link := range graph.Links {
+		attributes := []string{fmt.Sprintf("value=%s", dotQuote(formatWeight(link.Value)))}
+		if link.Type != "" {
+			attributes = append(attributes, fmt.Sprintf("type=%s", dotQuote(link.Type)))
+		}
+		b.WriteString(fmt.Sprintf("\t%s %s %s [%s];\n", dotQuote(link.Source), connector, dotQuote(link.Target), strings.Join(attributes, ", ")))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote returns a DOT quoted string, escaping quotes and backslashes and keeping line breaks on one line
+func dotQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	return `"` + replacer.Replace(value) + `"`
+}
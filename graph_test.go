@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestDotQuote(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "Guardian", want: `"Guardian"`},
+		{value: `Say "hi"`, want: `"Say \"hi\""`},
+		{value: `back\slash`, want: `"back\\slash"`},
+		{value: "two\r\nlines", want: `"two\nlines"`},
+		{value: "", want: `""`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if got := dotQuote(test.value); got != test.want {
+				t.Errorf("dotQuote(%q) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// GraphAnalysis holds the community and centrality results of a single WhoPlaysWithWho run
+type GraphAnalysis struct {
+	RunTime     time.Time        `bson:"RunTime"`
+	StartDate   time.Time        `bson:"StartDate"`
+	EndDate     time.Time        `bson:"EndDate"`
+	Categories  []string         `bson:"Categories,omitempty"`
+	CoPlayMode  CoPlayMode       `bson:"CoPlayMode"`
+	Weight      EdgeWeight       `bson:"Weight"`
+	Communities int              `bson:"Communities"`
+	Members     []MemberAnalysis `bson:"Members"`
+}
+
+type MemberAnalysis struct {
+	MembershipID   string  `bson:"MembershipID"`
+	DisplayName    string  `bson:"DisplayName"`
+	Community      int     `bson:"Community"`
+	Degree         int     `bson:"Degree"`
+	WeightedDegree float64 `bson:"WeightedDegree"`
+	Betweenness    float64 `bson:"Betweenness"`
+	Eigenvector    float64 `bson:"Eigenvector"`
+	Isolated       bool    `bson:"Isolated"`
+}
+
+// AnalyseGraph finds the communities in the graph with the Louvain method, calculates the degree, betweenness and
+// eigenvector centrality of every member, and records the results on the graph nodes. In a fireteam mode graph only
+// the fireteam links are used. Members without any link to another member are isolated and form no community, which
+// is recorded as community 0.
+func AnalyseGraph(graph *Graph) []MemberAnalysis {
+	weights := undirectedWeights(*graph)
+	communities := louvainCommunities(weights)
+	betweenness := betweennessCentrality(weights)
+	eigenvector := eigenvectorCentrality(weights)
+
+	members := []MemberAnalysis{}
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		degree := 0
+		weightedDegree := 0.0
+		for _, weight := range weights[i] {
+			if weight > 0 {
+				degree++
+				weightedDegree += weight
+			}
+		}
+
+		node.Community = communities[i]
+		node.Group = communities[i]
+		node.Degree = degree
+		node.Betweenness = betweenness[i]
+		node.Eigenvector = eigenvector[i]
+		node.Isolated = degree == 0
+
+		members = append(members, MemberAnalysis{
+			MembershipID:   node.ID,
+			DisplayName:    node.Name,
+			Community:      node.Community,
+			Degree:         degree,
+			WeightedDegree: weightedDegree,
+			Betweenness:    node.Betweenness,
+			Eigenvector:    node.Eigenvector,
+			Isolated:       node.Isolated,
+		})
+	}
+
+	return members
+}
+
+// undirectedWeights returns the symmetric weight matrix of the links between different members. A directed graph has
+// every pair linked both ways, so its weights are halved to count each pair once. Links between members that only
+// shared an instance in a fireteam mode graph are left out, so matchmade players do not join communities or connect
+// them.
+func undirectedWeights(graph Graph) [][]float64 {
+	index := map[string]int{}
+	for i, node := range graph.Nodes {
+		index[node.ID] = i
+	}
+
+	weights := make([][]float64, len(graph.Nodes))
+	for i := range weights {
+		weights[i] = make([]float64, len(graph.Nodes))
+	}
+
+	for _, link := range graph.Links {
+		source, ok1 := index[link.Source]
+		target, ok2 := index[link.Target]
+		if !ok1 || !ok2 || source == target || link.Type == LinkInstance {
+			continue
+		}
+		value := link.Value
+		if graph.Directed {
+			value = value / 2
+		}
+		weights[source][target] += value
+		weights[target][source] += value
+	}
+
+	return weights
+}
+
+// louvainCommunities finds communities by Louvain modularity optimisation. Members are moved to the neighbouring
+// community with the largest modularity gain until no move improves it, after which every community is merged into a
+// single node and the process repeats on the merged graph. Nodes and communities are visited in order, so the result
+// is deterministic. Communities are numbered from 1 by decreasing size.
+func louvainCommunities(weights [][]float64) []int {
+	membership := make([]int, len(weights))
+	for i := range membership {
+		membership[i] = i
+	}
+
+	current := weights
+	for {
+		communities, moved := louvainLevel(current)
+		if !moved {
+			break
+		}
+
+		// Number the communities found on this level and merge each of them into a single node
+		numbers := map[int]int{}
+		for _, community := range communities {
+			if _, ok := numbers[community]; !ok {
+				numbers[community] = len(numbers)
+			}
+		}
+		for i := range membership {
+			membership[i] = numbers[communities[membership[i]]]
+		}
+
+		merged := make([][]float64, len(numbers))
+		for i := range merged {
+			merged[i] = make([]float64, len(numbers))
+		}
+		for a := range current {
+			for b, weight := range current[a] {
+				merged[numbers[communities[a]]][numbers[communities[b]]] += weight
+			}
+		}
+		current = merged
+	}
+
+	// Renumber the communities by size, leaving isolated members in community 0
+	sizes := map[int]int{}
+	for i, community := range membership {
+		if hasNeighbours(weights[i]) {
+			sizes[community]++
+		}
+	}
+	ordered := []int{}
+	for community := range sizes {
+		ordered = append(ordered, community)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if sizes[ordered[i]] == sizes[ordered[j]] {
+			return ordered[i] < ordered[j]
+		}
+		return sizes[ordered[i]] > sizes[ordered[j]]
+	})
+	numbers := map[int]int{}
+	for i, community := range ordered {
+		numbers[community] = i + 1
+	}
+
+	communities := make([]int, len(membership))
+	for i, community := range membership {
+		if hasNeighbours(weights[i]) {
+			communities[i] = numbers[community]
+		}
+	}
+
+	return communities
+}
+
+// louvainLevel runs the node moving phase of the Louvain method on a graph whose diagonal holds the weight within
+// each node, and reports whether any node changed community
+func louvainLevel(weights [][]float64) ([]int, bool) {
+	n := len(weights)
+	community := make([]int, n)
+	degree := make([]float64, n)
+	total := make([]float64, n)
+	twiceTotalWeight := 0.0
+	for i := range weights {
+		community[i] = i
+		for _, weight := range weights[i] {
+			degree[i] += weight
+		}
+		total[i] = degree[i]
+		twiceTotalWeight += degree[i]
+	}
+	if twiceTotalWeight == 0 {
+		return community, false
+	}
+
+	improved := false
+	for pass := 0; pass < 100; pass++ {
+		moved := false
+		for i := range weights {
+			current := community[i]
+			total[current] -= degree[i]
+
+			links := map[int]float64{}
+			for j, weight := range weights[i] {
+				if j != i && weight > 0 {
+					links[community[j]] += weight
+				}
+			}
+			candidates := []int{}
+			for c := range links {
+				candidates = append(candidates, c)
+			}
+			sort.Ints(candidates)
+
+			best := current
+			bestGain := links[current] - total[current]*degree[i]/twiceTotalWeight
+			for _, c := range candidates {
+				gain := links[c] - total[c]*degree[i]/twiceTotalWeight
+				if gain > bestGain+1e-12 {
+					best = c
+					bestGain = gain
+				}
+			}
+
+			total[best] += degree[i]
+			community[i] = best
+			if best != current {
+				moved = true
+				improved = true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	return community, improved
+}
+
+func hasNeighbours(weights []float64) bool {
+	for _, weight := range weights {
+		if weight > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// betweennessCentrality calculates the normalised betweenness of every member over unweighted shortest paths, using
+// Brandes' algorithm
+func betweennessCentrality(weights [][]float64) []float64 {
+	n := len(weights)
+	centrality := make([]float64, n)
+
+	for s := 0; s < n; s++ {
+		stack := []int{}
+		predecessors := make([][]int, n)
+		paths := make([]float64, n)
+		distance := make([]int, n)
+		for i := range distance {
+			distance[i] = -1
+		}
+		paths[s] = 1
+		distance[s] = 0
+
+		queue := []int{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+			for w, weight := range weights[v] {
+				if weight <= 0 {
+					continue
+				}
+				if distance[w] < 0 {
+					distance[w] = distance[v] + 1
+					queue = append(queue, w)
+				}
+				if distance[w] == distance[v]+1 {
+					paths[w] += paths[v]
+					predecessors[w] = append(predecessors[w], v)
+				}
+			}
+		}
+
+		dependency := make([]float64, n)
+		for len(stack) > 0 {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, v := range predecessors[w] {
+				dependency[v] += paths[v] / paths[w] * (1 + dependency[w])
+			}
+			if w != s {
+				centrality[w] += dependency[w]
+			}
+		}
+	}
+
+	// Every path is found from both of its ends, after which the result is scaled by the number of pairs
+	if n > 2 {
+		scale := 1 / float64((n-1)*(n-2))
+		for i := range centrality {
+			centrality[i] = centrality[i] * scale
+		}
+	}
+
+	return centrality
+}
+
+// eigenvectorCentrality calculates the weighted eigenvector centrality of every member by power iteration, scaled so
+// that the most central member scores 1
+func eigenvectorCentrality(weights [][]float64) []float64 {
+	n := len(weights)
+	centrality := make([]float64, n)
+	for i := range centrality {
+		centrality[i] = 1
+	}
+
+	for iteration := 0; iteration < 100; iteration++ {
+		next := make([]float64, n)
+		for i := range weights {
+			// Adding the node's own score keeps the iteration from oscillating on bipartite graphs
+			next[i] = centrality[i]
+			for j, weight := range weights[i] {
+				next[i] += weight * centrality[j]
+			}
+		}
+
+		max := 0.0
+		for _, value := range next {
+			max = math.Max(max, value)
+		}
+		if max == 0 {
+			return next
+		}
+
+		delta := 0.0
+		for i := range next {
+			next[i] = next[i] / max
+			delta += math.Abs(next[i] - centrality[i])
+		}
+		centrality = next
+		if delta < 1e-9 {
+			break
+		}
+	}
+
+	// Isolated members only ever keep their own score, which says nothing about their centrality
+	for i := range weights {
+		if !hasNeighbours(weights[i]) {
+			centrality[i] = 0
+		}
+	}
+
+	return centrality
+}
+
+// modularity calculates the modularity of the division of the graph into communities, ignoring community 0
+func modularity(weights [][]float64, communities []int) float64 {
+	degree := make([]float64, len(weights))
+	twiceTotalWeight := 0.0
+	for i := range weights {
+		for _, weight := range weights[i] {
+			degree[i] += weight
+		}
+		twiceTotalWeight += degree[i]
+	}
+	if twiceTotalWeight == 0 {
+		return 0
+	}
+
+	q := 0.0
+	for i := range weights {
+		for j := range weights {
+			if communities[i] == 0 || communities[i] != communities[j] {
+				continue
+			}
+			q += weights[i][j] - degree[i]*degree[j]/twiceTotalWeight
+		}
+	}
+
+	return q / twiceTotalWeight
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// linkedWeights returns the symmetric weight matrix of n members with the given pairs linked with weight 1
+func linkedWeights(n int, pairs [][2]int) [][]float64 {
+	weights := make([][]float64, n)
+	for i := range weights {
+		weights[i] = make([]float64, n)
+	}
+	for _, pair := range pairs {
+		weights[pair[0]][pair[1]] = 1
+		weights[pair[1]][pair[0]] = 1
+	}
+
+	return weights
+}
+
+func TestUndirectedWeights(t *testing.T) {
+	nodes := []GraphNode{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	tests := []struct {
+		name  string
+		graph Graph
+		want  [][]float64
+	}{
+		{
+			name: "undirected",
+			graph: Graph{Nodes: nodes, Links: []GraphLink{
+				{Source: "a", Target: "b", Value: 2},
+				{Source: "b", Target: "c", Value: 1},
+			}},
+			want: [][]float64{{0, 2, 0}, {2, 0, 1}, {0, 1, 0}},
+		},
+		{
+			name: "directed links are halved",
+			graph: Graph{Directed: true, Nodes: nodes, Links: []GraphLink{
+				{Source: "a", Target: "b", Value: 2},
+				{Source: "b", Target: "a", Value: 2},
+			}},
+			want: [][]float64{{0, 2, 0}, {2, 0, 0}, {0, 0, 0}},
+		},
+		{
+			name: "instance links, self links and unknown members are left out",
+			graph: Graph{Nodes: nodes, Links: []GraphLink{
+				{Source: "a", Target: "b", Type: LinkFireteam, Value: 1},
+				{Source: "a", Target: "c", Type: LinkInstance, Value: 3},
+				{Source: "a", Target: "a", Value: 1},
+				{Source: "a", Target: "x", Value: 1},
+			}},
+			want: [][]float64{{0, 1, 0}, {1, 0, 0}, {0, 0, 0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := undirectedWeights(test.graph)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("undirectedWeights() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLouvainCommunities(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights [][]float64
+		want    []int
+	}{
+		{
+			name:    "empty",
+			weights: [][]float64{},
+			want:    []int{},
+		},
+		{
+			name:    "no links",
+			weights: linkedWeights(3, nil),
+			want:    []int{0, 0, 0},
+		},
+		{
+			name:    "two triangles joined by a bridge",
+			weights: linkedWeights(6, [][2]int{{0, 1}, {1, 2}, {0, 2}, {3, 4}, {4, 5}, {3, 5}, {2, 3}}),
+			want:    []int{1, 1, 1, 2, 2, 2},
+		},
+		{
+			name:    "numbered by decreasing size with isolated members left out",
+			weights: linkedWeights(7, [][2]int{{0, 1}, {2, 3}, {3, 4}, {2, 4}, {3, 5}, {4, 5}, {2, 5}}),
+			want:    []int{2, 2, 1, 1, 1, 1, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := louvainCommunities(test.weights)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("louvainCommunities() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBetweennessCentrality(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights [][]float64
+		want    []float64
+	}{
+		{
+			name:    "path",
+			weights: linkedWeights(3, [][2]int{{0, 1}, {1, 2}}),
+			want:    []float64{0, 1, 0},
+		},
+		{
+			name:    "star",
+			weights: linkedWeights(4, [][2]int{{0, 1}, {0, 2}, {0, 3}}),
+			want:    []float64{1, 0, 0, 0},
+		},
+		{
+			name:    "square splits paths between both routes",
+			weights: linkedWeights(4, [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 0}}),
+			want:    []float64{1.0 / 6, 1.0 / 6, 1.0 / 6, 1.0 / 6},
+		},
+		{
+			name:    "triangle",
+			weights: linkedWeights(3, [][2]int{{0, 1}, {1, 2}, {0, 2}}),
+			want:    []float64{0, 0, 0},
+		},
+		{
+			name:    "pair",
+			weights: linkedWeights(2, [][2]int{{0, 1}}),
+			want:    []float64{0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := betweennessCentrality(test.weights)
+			if !floatsEqual(got, test.want) {
+				t.Errorf("betweennessCentrality() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestModularity(t *testing.T) {
+	twoPairs := linkedWeights(4, [][2]int{{0, 1}, {2, 3}})
+	tests := []struct {
+		name        string
+		weights     [][]float64
+		communities []int
+		want        float64
+	}{
+		{
+			name:        "one community per component",
+			weights:     twoPairs,
+			communities: []int{1, 1, 2, 2},
+			want:        0.5,
+		},
+		{
+			name:        "single community",
+			weights:     twoPairs,
+			communities: []int{1, 1, 1, 1},
+			want:        0,
+		},
+		{
+			name:        "communities cutting every link",
+			weights:     twoPairs,
+			communities: []int{1, 2, 1, 2},
+			want:        -0.5,
+		},
+		{
+			name:        "community 0 is ignored",
+			weights:     twoPairs,
+			communities: []int{0, 0, 0, 0},
+			want:        0,
+		},
+		{
+			name:        "no links",
+			weights:     linkedWeights(2, nil),
+			communities: []int{1, 2},
+			want:        0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := modularity(test.weights, test.communities)
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("modularity() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func floatsEqual(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// WindowPeriod is the length of the windows the social graph evolution is computed over
+type WindowPeriod int
+
+const (
+	// WindowWeekly uses windows of a week, rolling windows advance a day at a time
+	WindowWeekly WindowPeriod = iota
+	// WindowMonthly uses windows of a calendar month, rolling windows advance a week at a time
+	WindowMonthly
+)
+
+// GraphSnapshot is the social graph of a single window, as persisted by GraphEvolution
+type GraphSnapshot struct {
+	RunTime     time.Time        `bson:"RunTime"`
+	WindowStart time.Time        `bson:"WindowStart"`
+	WindowEnd   time.Time        `bson:"WindowEnd"`
+	Period      WindowPeriod     `bson:"Period"`
+	Rolling     bool             `bson:"Rolling"`
+	Categories  []string         `bson:"Categories,omitempty"`
+	CoPlayMode  CoPlayMode       `bson:"CoPlayMode"`
+	Weight      EdgeWeight       `bson:"Weight"`
+	Communities int              `bson:"Communities"`
+	Modularity  float64          `bson:"Modularity"`
+	Members     []MemberAnalysis `bson:"Members"`
+	Links       []GraphLink      `bson:"Links"`
+}
+
+// CommunityChange is a member moving between communities from one window to the next. Community 0 means the member
+// did not play with anyone in that window.
+type CommunityChange struct {
+	MembershipID string `json:"id"`
+	DisplayName  string `json:"name"`
+	From         int    `json:"from"`
+	To           int    `json:"to"`
+}
+
+// TimelineFrame is a single window of the social graph timeline, along with the changes since the previous window
+type TimelineFrame struct {
+	Start            time.Time         `json:"start"`
+	End              time.Time         `json:"end"`
+	Communities      int               `json:"communities"`
+	Modularity       float64           `json:"modularity"`
+	Nodes            []GraphNode       `json:"nodes"`
+	Links            []GraphLink       `json:"links"`
+	NewLinks         []GraphLink       `json:"newLinks"`
+	LostLinks        []GraphLink       `json:"lostLinks"`
+	CommunityChanges []CommunityChange `json:"communityChanges"`
+}
+
+// GraphTimeline is the evolution of the social graph over consecutive windows
+type GraphTimeline struct {
+	Frames []TimelineFrame `json:"frames"`
+}
+
+// GraphEvolution computes the social graph for every window between the start and end date, stores a snapshot of each
+// window and writes the timeline, with the new and lost links and community changes between windows, as JSON or as a
+// dynamic GEXF graph for the Gephi timeline. Communities keep their number from one window to the next when they
+// share most of their members, so a growing number of communities shows the clan splitting up.
+func GraphEvolution(startDate time.Time, endDate time.Time, postfix string, period WindowPeriod, rolling bool, includeDeleted bool, categories []string, coPlayMode CoPlayMode, weight EdgeWeight, completedOnly bool, format string) {
+	if format != GraphJSON && format != GraphGEXF && format != "" {
+		fmt.Printf("Error writing timeline: unsupported timeline format %s\r\n", format)
+		return
+	}
+
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		fmt.Printf("Error reading categories: %s\r\n", err.Error())
+		return
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.%s", config.ClanID, postfix, GraphExtension(format)))
+	if err != nil {
+		fmt.Printf("Error opening file: %s", err.Error())
+		return
+	}
+	defer f.Close()
+
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	var dbPlayers []Player
+	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		fmt.Printf("Error reading members: %s\r\n", err.Error())
+		return
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			fmt.Printf("Error reading deleted characters: %s\r\n", err.Error())
+			return
+		}
+	}
+
+	classes, err := MemberClasses()
+	if err != nil {
+		fmt.Printf("Error reading characters: %s\r\n", err.Error())
+		return
+	}
+
+	collectionSnapshots := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("GraphSnapshots")
+	timeline := GraphTimeline{Frames: []TimelineFrame{}}
+	var previous *TimelineFrame
+	nextCommunity := 1
+
+	fmt.Printf("Start,End,Links,New,Lost,Communities,Modularity,Changes\r\n")
+	for _, window := range GraphWindows(startDate, endDate, period, rolling) {
+		coPlay, err := CoPlayMatrix(dbPlayers, ActivityQuery(window[0], window[1], modes), excludedCharacters, completedOnly)
+		if err != nil {
+			fmt.Printf("Error reading activities: %s\r\n", err.Error())
+			return
+		}
+
+		graph := BuildCoPlayGraph(dbPlayers, coPlay, classes, window[1], false, coPlayMode, weight)
+		members := AnalyseGraph(&graph)
+		if previous != nil {
+			TrackCommunities(previous.Nodes, &graph, members, &nextCommunity)
+		} else {
+			nextCommunity = CountCommunities(members) + 1
+		}
+
+		communities := make([]int, len(graph.Nodes))
+		for i, node := range graph.Nodes {
+			communities[i] = node.Community
+		}
+
+		frame := TimelineFrame{
+			Start:       window[0],
+			End:         window[1],
+			Communities: CountCommunities(members),
+			Modularity:  modularity(undirectedWeights(graph), communities),
+			Nodes:       []GraphNode{},
+			Links:       graph.Links,
+		}
+		for _, node := range graph.Nodes {
+			if node.Activities > 0 || node.Degree > 0 {
+				frame.Nodes = append(frame.Nodes, node)
+			}
+		}
+		if previous != nil {
+			frame.NewLinks, frame.LostLinks = DiffLinks(previous.Links, frame.Links)
+			frame.CommunityChanges = DiffCommunities(previous.Nodes, frame.Nodes)
+		} else {
+			frame.NewLinks = frame.Links
+			frame.LostLinks = []GraphLink{}
+			frame.CommunityChanges = []CommunityChange{}
+		}
+
+		snapshot := GraphSnapshot{
+			RunTime:     time.Now(),
+			WindowStart: window[0],
+			WindowEnd:   window[1],
+			Period:      period,
+			Rolling:     rolling,
+			Categories:  categories,
+			CoPlayMode:  coPlayMode,
+			Weight:      weight,
+			Communities: frame.Communities,
+			Modularity:  frame.Modularity,
+			Members:     members,
+			Links:       frame.Links,
+		}
+		err = collectionSnapshots.Insert(snapshot)
+		if err != nil {
+			fmt.Printf("Error inserting graph snapshot: %s\r\n", err.Error())
+		}
+
+		fmt.Printf("%s,%s,%d,%d,%d,%d,%s,%d\r\n", window[0].Format("2006-01-02"), window[1].Format("2006-01-02"),
+			len(frame.Links), len(frame.NewLinks), len(frame.LostLinks), frame.Communities, formatWeight(frame.Modularity), len(frame.CommunityChanges))
+
+		timeline.Frames = append(timeline.Frames, frame)
+		previous = &timeline.Frames[len(timeline.Frames)-1]
+	}
+
+	if format == GraphGEXF {
+		err = WriteTimelineGEXF(f, timeline)
+	} else {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(timeline)
+	}
+	if err != nil {
+		fmt.Printf("Error writing timeline: %s\r\n", err.Error())
+	}
+}
+
+// GraphWindows splits the period between the start and end date into windows. Fixed windows follow each other, while
+// rolling windows overlap and advance a day at a time for weekly windows and a week at a time for monthly windows. The
+// last window is cut short at the end date.
+func GraphWindows(startDate time.Time, endDate time.Time, period WindowPeriod, rolling bool) [][2]time.Time {
+	windowEnd := func(start time.Time) time.Time {
+		if period == WindowMonthly {
+			return start.AddDate(0, 1, 0)
+		}
+		return start.AddDate(0, 0, 7)
+	}
+	step := windowEnd
+	if rolling {
+		step = func(start time.Time) time.Time {
+			if period == WindowMonthly {
+				return start.AddDate(0, 0, 7)
+			}
+			return start.AddDate(0, 0, 1)
+		}
+	}
+
+	windows := [][2]time.Time{}
+	for start := startDate; start.Before(endDate); start = step(start) {
+		end := windowEnd(start)
+		if end.After(endDate) {
+			end = endDate
+		}
+		windows = append(windows, [2]time.Time{start, end})
+		if rolling && !end.Before(endDate) {
+			break
+		}
+	}
+
+	return windows
+}
+
+// TrackCommunities renumbers the communities of the graph so that each takes the number of the community in the
+// previous window it shares the most members with. Communities without such a match get a new number, the largest
+// community the lowest.
+func TrackCommunities(previousNodes []GraphNode, graph *Graph, members []MemberAnalysis, nextCommunity *int) {
+	previousCommunity := map[string]int{}
+	for _, node := range previousNodes {
+		previousCommunity[node.ID] = node.Community
+	}
+
+	// Count the shared members of every pair of current and previous communities
+	type pair struct {
+		current  int
+		previous int
+		shared   int
+	}
+	counts := map[[2]int]int{}
+	for _, node := range graph.Nodes {
+		if node.Community == 0 || previousCommunity[node.ID] == 0 {
+			continue
+		}
+		counts[[2]int{node.Community, previousCommunity[node.ID]}]++
+	}
+	pairs := []pair{}
+	for key, shared := range counts {
+		pairs = append(pairs, pair{current: key[0], previous: key[1], shared: shared})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].shared != pairs[j].shared {
+			return pairs[i].shared > pairs[j].shared
+		}
+		if pairs[i].current != pairs[j].current {
+			return pairs[i].current < pairs[j].current
+		}
+		return pairs[i].previous < pairs[j].previous
+	})
+
+	numbers := map[int]int{}
+	taken := map[int]bool{}
+	for _, p := range pairs {
+		if _, ok := numbers[p.current]; ok || taken[p.previous] {
+			continue
+		}
+		numbers[p.current] = p.previous
+		taken[p.previous] = true
+	}
+
+	// New numbers are handed out largest unmatched community first
+	sizes := map[int]int{}
+	for _, node := range graph.Nodes {
+		if _, ok := numbers[node.Community]; !ok && node.Community != 0 {
+			sizes[node.Community]++
+		}
+	}
+	unmatched := []int{}
+	for community := range sizes {
+		unmatched = append(unmatched, community)
+	}
+	sort.Slice(unmatched, func(i, j int) bool {
+		if sizes[unmatched[i]] != sizes[unmatched[j]] {
+			return sizes[unmatched[i]] > sizes[unmatched[j]]
+		}
+		return unmatched[i] < unmatched[j]
+	})
+	for _, community := range unmatched {
+		numbers[community] = *nextCommunity
+		*nextCommunity++
+	}
+	for i := range graph.Nodes {
+		graph.Nodes[i].Community = numbers[graph.Nodes[i].Community]
+		graph.Nodes[i].Group = graph.Nodes[i].Community
+	}
+	for i := range members {
+		members[i].Community = numbers[members[i].Community]
+	}
+}
+
+// CountCommunities returns the number of distinct communities, not counting isolated members
+func CountCommunities(members []MemberAnalysis) int {
+	communities := map[int]bool{}
+	for _, member := range members {
+		if member.Community != 0 {
+			communities[member.Community] = true
+		}
+	}
+
+	return len(communities)
+}
+
+// DiffLinks returns the links that only exist in the current window and the links that only existed in the previous
+// window
+func DiffLinks(previous []GraphLink, current []GraphLink) ([]GraphLink, []GraphLink) {
+	previousKeys := map[string]bool{}
+	for _, link := range previous {
+		previousKeys[linkKey(link)] = true
+	}
+	currentKeys := map[string]bool{}
+	for _, link := range current {
+		currentKeys[linkKey(link)] = true
+	}
+
+	newLinks := []GraphLink{}
+	for _, link := range current {
+		if !previousKeys[linkKey(link)] {
+			newLinks = append(newLinks, link)
+		}
+	}
+	lostLinks := []GraphLink{}
+	for _, link := range previous {
+		if !currentKeys[linkKey(link)] {
+			lostLinks = append(lostLinks, link)
+		}
+	}
+
+	return newLinks, lostLinks
+}
+
+// linkKey identifies an undirected link by its members and type
+func linkKey(link GraphLink) string {
+	if link.Source > link.Target {
+		return link.Target + "|" + link.Source + "|" + link.Type
+	}
+
+	return link.Source + "|" + link.Target + "|" + link.Type
+}
+
+// DiffCommunities returns the members whose community differs between the previous and current window
+func DiffCommunities(previous []GraphNode, current []GraphNode) []CommunityChange {
+	previousCommunity := map[string]int{}
+	for _, node := range previous {
+		previousCommunity[node.ID] = node.Community
+	}
+	currentCommunity := map[string]int{}
+	for _, node := range current {
+		currentCommunity[node.ID] = node.Community
+	}
+
+	changes := []CommunityChange{}
+	for _, node := range current {
+		if previousCommunity[node.ID] != node.Community {
+			changes = append(changes, CommunityChange{MembershipID: node.ID, DisplayName: node.Name, From: previousCommunity[node.ID], To: node.Community})
+		}
+	}
+	for _, node := range previous {
+		if _, ok := currentCommunity[node.ID]; !ok && node.Community != 0 {
+			changes = append(changes, CommunityChange{MembershipID: node.ID, DisplayName: node.Name, From: node.Community, To: 0})
+		}
+	}
+
+	return changes
+}
+
+type gexfTimelineDocument struct {
+	XMLName xml.Name `xml:"gexf"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		Mode            string                   `xml:"mode,attr"`
+		DefaultEdgeType string                   `xml:"defaultedgetype,attr"`
+		TimeFormat      string                   `xml:"timeformat,attr"`
+		Attributes      []gexfTimelineAttributes `xml:"attributes"`
+		Nodes           []gexfTimelineNode       `xml:"nodes>node"`
+		Edges           []gexfTimelineEdge       `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+type gexfTimelineAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Mode       string          `xml:"mode,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfSpell struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type gexfTimelineAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type gexfTimelineNode struct {
+	ID        string                 `xml:"id,attr"`
+	Label     string                 `xml:"label,attr"`
+	AttValues []gexfTimelineAttValue `xml:"attvalues>attvalue"`
+	Spells    []gexfSpell            `xml:"spells>spell"`
+}
+
+type gexfTimelineEdge struct {
+	ID        string                 `xml:"id,attr"`
+	Source    string                 `xml:"source,attr"`
+	Target    string                 `xml:"target,attr"`
+	Label     string                 `xml:"label,attr,omitempty"`
+	AttValues []gexfTimelineAttValue `xml:"attvalues>attvalue"`
+	Spells    []gexfSpell            `xml:"spells>spell"`
+}
+
+// WriteTimelineGEXF writes the timeline as a dynamic GEXF graph. Each window is shown from its start until the start of
+// the next window, so overlapping rolling windows play back one after the other.
+func WriteTimelineGEXF(w io.Writer, timeline GraphTimeline) error {
+	doc := gexfTimelineDocument{Xmlns: "http://www.gexf.net/1.2draft", Version: "1.2"}
+	doc.Graph.Mode = "dynamic"
+	doc.Graph.DefaultEdgeType = "undirected"
+	doc.Graph.TimeFormat = "dateTime"
+
+	nodeAttributeList := gexfTimelineAttributes{Class: "node", Mode: "dynamic"}
+	for _, attribute := range nodeAttributes(GraphNode{}) {
+		gexfType := attribute.Type
+		if gexfType == "int" {
+			gexfType = "integer"
+		}
+		nodeAttributeList.Attributes = append(nodeAttributeList.Attributes, gexfAttribute{ID: attribute.Name, Title: attribute.Name, Type: gexfType})
+	}
+	doc.Graph.Attributes = []gexfTimelineAttributes{
+		nodeAttributeList,
+		{Class: "edge", Mode: "dynamic", Attributes: []gexfAttribute{{ID: "weight", Title: "Weight", Type: "double"}}},
+	}
+
+	nodes := map[string]*gexfTimelineNode{}
+	nodeOrder := []string{}
+	edges := map[string]*gexfTimelineEdge{}
+	edgeOrder := []string{}
+	for i, frame := range timeline.Frames {
+		start := frame.Start.Format(time.RFC3339)
+		end := frame.End.Format(time.RFC3339)
+		if i+1 < len(timeline.Frames) {
+			end = timeline.Frames[i+1].Start.Format(time.RFC3339)
+		}
+
+		for _, node := range frame.Nodes {
+			gexfNode, ok := nodes[node.ID]
+			if !ok {
+				gexfNode = &gexfTimelineNode{ID: node.ID}
+				nodes[node.ID] = gexfNode
+				nodeOrder = append(nodeOrder, node.ID)
+			}
+			gexfNode.Label = node.Name
+			gexfNode.Spells = appendSpell(gexfNode.Spells, start, end)
+			for _, attribute := range nodeAttributes(node) {
+				gexfNode.AttValues = append(gexfNode.AttValues, gexfTimelineAttValue{For: attribute.Name, Value: attribute.Value, Start: start, End: end})
+			}
+		}
+
+		for _, link := range frame.Links {
+			key := linkKey(link)
+			gexfEdge, ok := edges[key]
+			if !ok {
+				gexfEdge = &gexfTimelineEdge{ID: strconv.Itoa(len(edgeOrder)), Source: link.Source, Target: link.Target, Label: link.Type}
+				edges[key] = gexfEdge
+				edgeOrder = append(edgeOrder, key)
+			}
+			gexfEdge.Spells = appendSpell(gexfEdge.Spells, start, end)
+			gexfEdge.AttValues = append(gexfEdge.AttValues, gexfTimelineAttValue{For: "weight", Value: formatWeight(link.Value), Start: start, End: end})
+		}
+	}
+
+	for _, id := range nodeOrder {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, *nodes[id])
+	}
+	for _, key := range edgeOrder {
+		doc.Graph.Edges = append(doc.Graph.Edges, *edges[key])
+	}
+
+	return writeXML(w, doc)
+}
+
+// appendSpell adds a spell, extending the last one instead when the new spell follows on from it
+func appendSpell(spells []gexfSpell, start string, end string) []gexfSpell {
+	if len(spells) > 0 && spells[len(spells)-1].End == start {
+		spells[len(spells)-1].End = end
+		return spells
+	}
+
+	return append(spells, gexfSpell{Start: start, End: end})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// InactiveMember is a member whose most recent activity is older than the inactivity threshold
+type InactiveMember struct {
+	MembershipID     string          `json:"membershipId"`
+	DisplayName      string          `json:"displayName"`
+	ClanRole         string          `json:"clanRole"`
+	LastSeen         time.Time       `json:"lastSeen"`
+	DaysInactive     int             `json:"daysInactive"`
+	LastActivity     string          `json:"lastActivity"`
+	LastActivityMode string          `json:"lastActivityMode"`
+	DaysInClan       int             `json:"daysInClan"`
+	Activities       int             `json:"activities"`
+	ClanActivities   int             `json:"clanActivities"`
+	CoPlayPartners   []CoPlayPartner `json:"coPlayPartners"`
+}
+
+// CoPlayPartner is a clan member someone played with, and the number of activities they played together
+type CoPlayPartner struct {
+	DisplayName string `json:"displayName"`
+	Activities  int    `json:"activities"`
+}
+
+// Sort orders of the inactivity report
+const (
+	SortLastSeen = "lastseen"
+	SortName     = "name"
+	SortTenure   = "tenure"
+	SortCoPlay   = "coplay"
+)
+
+// InactivityReport lists the enabled members that have not played for at least inactiveDays, based on the last played
+// date of all their characters and their most recent stored activity. For each of them it shows the activities they
+// played in the coPlayDays before they were last seen, how many of those were with other members, and with whom. The
+// report is written as TSV or JSON. With categories given, only activities in those categories count, so the last
+// played dates of the characters are ignored and members are inactive in those categories. Deleted characters and
+// their activities only count when they are included.
+func InactivityReport(inactiveDays int, coPlayDays int, sortBy string, postfix string, format string, categories []string, includeDeleted bool) error {
+	if format != "tsv" && format != "json" {
+		return fmt.Errorf("unsupported report format %s", format)
+	}
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		return err
+	}
+
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	collectionCharacters := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Characters")
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+
+	var dbPlayers []Player
+	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, player := range dbPlayers {
+		names[player.MembershipID] = player.DisplayName
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -inactiveDays)
+	inactive := []InactiveMember{}
+	for _, player := range dbPlayers {
+		member := InactiveMember{
+			MembershipID:   player.MembershipID,
+			DisplayName:    player.DisplayName,
+			ClanRole:       player.ClanRole.String(),
+			CoPlayPartners: []CoPlayPartner{},
+		}
+
+		if len(modes) == 0 {
+			var characters []Character
+			characterQuery := bson.M{"MembershipID": player.MembershipID}
+			if !includeDeleted {
+				characterQuery["Deleted"] = bson.M{"$ne": true}
+			}
+			err = collectionCharacters.Find(characterQuery).All(&characters)
+			if err != nil {
+				return err
+			}
+			for _, character := range characters {
+				if character.DateLastPlayed.After(member.LastSeen) {
+					member.LastSeen = character.DateLastPlayed
+				}
+			}
+		}
+
+		query := MemberEntryQuery(player.MembershipID, excludedCharacters)
+		if len(modes) > 0 {
+			query["ActivityDetails.Modes"] = bson.M{"$in": modes}
+		}
+		var lastActivity PGCR
+		err = collectionActivities.Find(query).Sort("-Period").Select(bson.M{"Period": 1, "ActivityDetails": 1}).One(&lastActivity)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		if err == nil {
+			member.LastActivity = ActivityName(lastActivity.ActivityDetails.ReferenceID)
+			member.LastActivityMode = lastActivity.ActivityDetails.Mode.String()
+			if lastActivity.Period.After(member.LastSeen) {
+				member.LastSeen = lastActivity.Period
+			}
+		}
+
+		if member.LastSeen.After(cutoff) {
+			continue
+		}
+
+		if !player.JoinDate.IsZero() {
+			member.DaysInClan = int(now.Sub(player.JoinDate).Hours() / 24)
+		}
+		if member.LastSeen.IsZero() {
+			member.DaysInactive = member.DaysInClan
+		} else {
+			member.DaysInactive = int(now.Sub(member.LastSeen).Hours() / 24)
+
+			member.Activities, member.ClanActivities, member.CoPlayPartners, err = RecentCoPlay(collectionActivities, player.MembershipID, member.LastSeen.AddDate(0, 0, -coPlayDays), member.LastSeen.Add(time.Second), names, modes, excludedCharacters)
+			if err != nil {
+				return err
+			}
+		}
+
+		inactive = append(inactive, member)
+	}
+
+	sort.SliceStable(inactive, func(i, j int) bool {
+		switch sortBy {
+		case SortName:
+			return strings.ToLower(inactive[i].DisplayName) < strings.ToLower(inactive[j].DisplayName)
+		case SortTenure:
+			return inactive[i].DaysInClan > inactive[j].DaysInClan
+		case SortCoPlay:
+			return inactive[i].ClanActivities > inactive[j].ClanActivities
+		}
+		return inactive[i].LastSeen.Before(inactive[j].LastSeen)
+	})
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.%s", config.ClanID, postfix, format))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "json" {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(inactive)
+	} else {
+		_, err = f.WriteString("player\tmembershipId\trole\tlastSeen\tdaysInactive\tlastActivity\tlastActivityMode\tdaysInClan\tactivities\tclanActivities\tcoPlayPartners\r\n")
+		for _, member := range inactive {
+			if err != nil {
+				break
+			}
+			lastSeen := ""
+			if !member.LastSeen.IsZero() {
+				lastSeen = member.LastSeen.Format("2006-01-02")
+			}
+			partners := []string{}
+			for _, partner := range member.CoPlayPartners {
+				partners = append(partners, fmt.Sprintf("%s (%d)", partner.DisplayName, partner.Activities))
+			}
+			_, err = f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t%s\r\n",
+				member.DisplayName, member.MembershipID, member.ClanRole, lastSeen, member.DaysInactive, member.LastActivity,
+				member.LastActivityMode, member.DaysInClan, member.Activities, member.ClanActivities, strings.Join(partners, ", ")))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d of %d members inactive for %d days or more\r\n", len(inactive), len(dbPlayers), inactiveDays)
+	return nil
+}
+
+// RecentCoPlay counts the activities of a member in the given modes between the start and end date, the activities
+// shared with other members, and the members they were shared with, most frequent first. No modes means all activities.
+// Entries of the excluded characters are ignored.
+func RecentCoPlay(collectionActivities *mgo.Collection, membershipID string, startDate time.Time, endDate time.Time, names map[string]string, modes []DestinyActivityModeType, excludedCharacters []string) (int, int, []CoPlayPartner, error) {
+	query := ActivityQuery(startDate, endDate, modes)
+	for key, value := range MemberEntryQuery(membershipID, excludedCharacters) {
+		query[key] = value
+	}
+
+	var activities []PGCR
+	err := collectionActivities.Find(query).Select(bson.M{
+		"Entries.Player.DestinyUserInfo.MembershipID": 1,
+		"Entries.CharacterID":                         1,
+	}).All(&activities)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	clanActivities := 0
+	counts := map[string]int{}
+	for _, activity := range activities {
+		DropCharacterEntries(&activity, excludedCharacters)
+		partners := map[string]bool{}
+		for _, entry := range activity.Entries {
+			partnerID := entry.Player.DestinyUserInfo.MembershipID
+			if _, ok := names[partnerID]; ok && partnerID != membershipID {
+				partners[partnerID] = true
+			}
+		}
+		if len(partners) > 0 {
+			clanActivities++
+		}
+		for partnerID := range partners {
+			counts[partnerID]++
+		}
+	}
+
+	partners := []CoPlayPartner{}
+	for partnerID, count := range counts {
+		partners = append(partners, CoPlayPartner{DisplayName: names[partnerID], Activities: count})
+	}
+	sort.Slice(partners, func(i, j int) bool {
+		if partners[i].Activities == partners[j].Activities {
+			return partners[i].DisplayName < partners[j].DisplayName
+		}
+		return partners[i].Activities > partners[j].Activities
+	})
+
+	return len(activities), clanActivities, partners, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Company is who a member played an activity with
+type Company string
+
+// Companies of an activity
+const (
+	CompanyClan      Company = "clan"
+	CompanyOutsiders Company = "outsiders"
+	CompanySolo      Company = "solo"
+)
+
+// IntegrationWindow counts the activities and playtime of a member in a window by the company they played in
+type IntegrationWindow struct {
+	Start      time.Time
+	End        time.Time
+	Activities map[Company]int
+	Seconds    map[Company]float64
+}
+
+// ActivityShare returns the share of the activities in the window played in a company
+func (window IntegrationWindow) ActivityShare(company Company) float64 {
+	total := window.Total()
+	if total == 0 {
+		return 0
+	}
+
+	return float64(window.Activities[company]) / float64(total)
+}
+
+// TimeShare returns the share of the playtime in the window spent in a company
+func (window IntegrationWindow) TimeShare(company Company) float64 {
+	total := 0.0
+	for _, seconds := range window.Seconds {
+		total += seconds
+	}
+	if total == 0 {
+		return 0
+	}
+
+	return window.Seconds[company] / total
+}
+
+// Total returns the number of activities in the window
+func (window IntegrationWindow) Total() int {
+	total := 0
+	for _, count := range window.Activities {
+		total += count
+	}
+
+	return total
+}
+
+// Hours returns the playtime in the window in hours
+func (window IntegrationWindow) Hours() float64 {
+	total := 0.0
+	for _, seconds := range window.Seconds {
+		total += seconds
+	}
+
+	return total / 3600
+}
+
+// ActivityCompany returns who a member played an activity with. Any other member makes it a clan activity, otherwise
+// any other player makes it an activity with outsiders, and without anyone else it was played solo. With coPlayMode set
+// to CoPlayFireteam, only players in the fireteam of the member count, so matchmade players in strikes and crucible are
+// not company. Entries without a fireteam ID never share a fireteam.
+func ActivityCompany(activity PGCR, membershipID string, members map[string]bool, coPlayMode CoPlayMode) Company {
+	fireteams := []string{}
+	for _, entry := range activity.Entries {
+		fireteamID := FireteamID(entry.Values.FireteamID.Basic)
+		if entry.Player.DestinyUserInfo.MembershipID == membershipID && fireteamID != "" {
+			fireteams = append(fireteams, fireteamID)
+		}
+	}
+
+	company := CompanySolo
+	for _, entry := range activity.Entries {
+		playerID := entry.Player.DestinyUserInfo.MembershipID
+		if playerID == membershipID {
+			continue
+		}
+		if coPlayMode == CoPlayFireteam && !ContainsString(fireteams, FireteamID(entry.Values.FireteamID.Basic)) {
+			continue
+		}
+		if members[playerID] {
+			return CompanyClan
+		}
+		company = CompanyOutsiders
+	}
+
+	return company
+}
+
+// ClanIntegration writes, for every enabled member and every weekly or monthly window between the start and end date,
+// the share of their activities and playtime spent with clan members, only with players from outside the clan, and
+// solo. A summary file holds the shares over the whole period and the change in the clan share between the first and
+// last window the member played in, as an indicator of whether they are growing into the clan or drifting away.
+func ClanIntegration(startDate time.Time, endDate time.Time, postfix string, period WindowPeriod, includeDeleted bool, categories []string, coPlayMode CoPlayMode) error {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		return err
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			return err
+		}
+	}
+
+	dbPlayers, err := GroupMembers("clan")
+	if err != nil {
+		return err
+	}
+	sort.Slice(dbPlayers, func(i, j int) bool {
+		return strings.ToLower(dbPlayers[i].DisplayName) < strings.ToLower(dbPlayers[j].DisplayName)
+	})
+	members := map[string]bool{}
+	memberIDs := []string{}
+	for _, player := range dbPlayers {
+		members[player.MembershipID] = true
+		memberIDs = append(memberIDs, player.MembershipID)
+	}
+
+	bounds := GraphWindows(startDate, endDate, period, false)
+	windows := map[string][]IntegrationWindow{}
+	for _, player := range dbPlayers {
+		windows[player.MembershipID] = make([]IntegrationWindow, len(bounds))
+		for i, bound := range bounds {
+			windows[player.MembershipID][i] = IntegrationWindow{
+				Start:      bound[0],
+				End:        bound[1],
+				Activities: map[Company]int{},
+				Seconds:    map[Company]float64{},
+			}
+		}
+	}
+
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+	query := ActivityQuery(startDate, endDate, modes)
+	query["Entries.Player.DestinyUserInfo.MembershipID"] = bson.M{"$in": memberIDs}
+
+	var activity PGCR
+	iter := collectionActivities.Find(query).Select(bson.M{
+		"Period": 1,
+		"Entries.Player.DestinyUserInfo.MembershipID": 1,
+		"Entries.CharacterID":                         1,
+		"Entries.Values.FireteamID":                   1,
+		"Entries.Values.TimePlayedSeconds":            1,
+	}).Iter()
+	for iter.Next(&activity) {
+		i := sort.Search(len(bounds), func(i int) bool {
+			return bounds[i][1].After(activity.Period)
+		})
+		if i == len(bounds) {
+			activity = PGCR{}
+			continue
+		}
+
+		played := map[string]float64{}
+		for _, entry := range activity.Entries {
+			membershipID := entry.Player.DestinyUserInfo.MembershipID
+			if members[membershipID] && !ContainsString(excludedCharacters, entry.CharacterID) {
+				played[membershipID] += entry.Values.TimePlayedSeconds.Basic.Value
+			}
+		}
+		for membershipID, seconds := range played {
+			company := ActivityCompany(activity, membershipID, members, coPlayMode)
+			windows[membershipID][i].Activities[company]++
+			windows[membershipID][i].Seconds[company] += seconds
+		}
+		activity = PGCR{}
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	summary, err := os.Create(fmt.Sprintf("ClanInspector%s_%s_summary.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer summary.Close()
+
+	f.WriteString("player\tmembershipId\tstart\tend\tactivities\tclan\toutsiders\tsolo\tclanShare\toutsiderShare\tsoloShare\thours\tclanTimeShare\toutsiderTimeShare\tsoloTimeShare\r\n")
+	summary.WriteString("player\tmembershipId\tactivities\thours\tclanShare\toutsiderShare\tsoloShare\tclanTimeShare\toutsiderTimeShare\tsoloTimeShare\tfirstClanShare\tlastClanShare\tclanShareChange\r\n")
+	for _, player := range dbPlayers {
+		total := IntegrationWindow{Activities: map[Company]int{}, Seconds: map[Company]float64{}}
+		played := []IntegrationWindow{}
+		for _, window := range windows[player.MembershipID] {
+			if window.Total() == 0 {
+				continue
+			}
+			played = append(played, window)
+			for _, company := range []Company{CompanyClan, CompanyOutsiders, CompanySolo} {
+				total.Activities[company] += window.Activities[company]
+				total.Seconds[company] += window.Seconds[company]
+			}
+
+			f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%.2f\t%s\t%s\t%s\r\n",
+				player.DisplayName, player.MembershipID, window.Start.Format("2006-01-02"), window.End.Format("2006-01-02"),
+				window.Total(), window.Activities[CompanyClan], window.Activities[CompanyOutsiders], window.Activities[CompanySolo],
+				formatDelta(window.ActivityShare(CompanyClan)), formatDelta(window.ActivityShare(CompanyOutsiders)),
+				formatDelta(window.ActivityShare(CompanySolo)), window.Hours(),
+				formatDelta(window.TimeShare(CompanyClan)), formatDelta(window.TimeShare(CompanyOutsiders)), formatDelta(window.TimeShare(CompanySolo))))
+		}
+		if len(played) == 0 {
+			continue
+		}
+
+		first := played[0].ActivityShare(CompanyClan)
+		last := played[len(played)-1].ActivityShare(CompanyClan)
+		summary.WriteString(fmt.Sprintf("%s\t%s\t%d\t%.2f\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\r\n",
+			player.DisplayName, player.MembershipID, total.Total(),
+			total.Hours(), formatDelta(total.ActivityShare(CompanyClan)), formatDelta(total.ActivityShare(CompanyOutsiders)),
+			formatDelta(total.ActivityShare(CompanySolo)), formatDelta(total.TimeShare(CompanyClan)),
+			formatDelta(total.TimeShare(CompanyOutsiders)), formatDelta(total.TimeShare(CompanySolo)),
+			formatDelta(first), formatDelta(last), formatDelta(last-first)))
+	}
+
+	return nil
+}
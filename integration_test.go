@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityCompany(t *testing.T) {
+	members := map[string]bool{"1": true, "2": true}
+	tests := []struct {
+		name       string
+		entries    []testEntry
+		coPlayMode CoPlayMode
+		want       Company
+	}{
+		{
+			name:       "solo",
+			entries:    []testEntry{{membershipID: "1", fireteamID: "10"}},
+			coPlayMode: CoPlayInstance,
+			want:       CompanySolo,
+		},
+		{
+			name:       "clan member in the instance",
+			entries:    []testEntry{{membershipID: "1", fireteamID: "10"}, {membershipID: "9", fireteamID: "10"}, {membershipID: "2", fireteamID: "20"}},
+			coPlayMode: CoPlayInstance,
+			want:       CompanyClan,
+		},
+		{
+			name:       "outsiders in the instance",
+			entries:    []testEntry{{membershipID: "1", fireteamID: "10"}, {membershipID: "9", fireteamID: "20"}},
+			coPlayMode: CoPlayInstance,
+			want:       CompanyOutsiders,
+		},
+		{
+			name:       "clan member in another fireteam",
+			entries:    []testEntry{{membershipID: "1", fireteamID: "10"}, {membershipID: "9", fireteamID: "10"}, {membershipID: "2", fireteamID: "20"}},
+			coPlayMode: CoPlayFireteam,
+			want:       CompanyOutsiders,
+		},
+		{
+			name:       "clan member in the fireteam",
+			entries:    []testEntry{{membershipID: "1", fireteamID: "10"}, {membershipID: "2", fireteamID: "10"}},
+			coPlayMode: CoPlayFireteam,
+			want:       CompanyClan,
+		},
+		{
+			name:       "entries without a fireteam never share one",
+			entries:    []testEntry{{membershipID: "1"}, {membershipID: "2"}},
+			coPlayMode: CoPlayFireteam,
+			want:       CompanySolo,
+		},
+	}
+
+	period := time.Date(2019, time.June, 3, 20, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ActivityCompany(testActivity(t, period, test.entries...), "1", members, test.coPlayMode)
+			if got != test.want {
+				t.Errorf("ActivityCompany() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
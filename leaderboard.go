@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Leaderboard stats derived from the stored activities instead of the PlayerStats batches
+const (
+	StatRaidClears     = "raid-clears"
+	StatDungeonClears  = "dungeon-clears"
+	StatFlawless       = "flawless"
+	StatPrecisionRatio = "precision-ratio"
+)
+
+// Suffix turning any leaderboard stat into a rate per hour played
+const perHourSuffix = "/hour"
+
+// LeaderboardEntry is the rank of a member on a leaderboard. PreviousRank is 0 when the member was not ranked at the
+// previous stats batch.
+type LeaderboardEntry struct {
+	Rank         int
+	PreviousRank int
+	MembershipID string
+	DisplayName  string
+	Value        float64
+}
+
+// Season is a named date window that leaderboards can be limited to. Start and End are the first and last day of the
+// season, an empty End means the season is still running.
+type Season struct {
+	Name  string `yaml:"Name"`
+	Start string `yaml:"Start"`
+	End   string `yaml:"End"`
+}
+
+// LookupSeason returns the start and end date of a configured season, the end being the start of the day after its
+// last day
+func LookupSeason(name string) (time.Time, time.Time, error) {
+	for _, season := range config.Seasons {
+		if !strings.EqualFold(season.Name, name) {
+			continue
+		}
+
+		start, err := time.Parse("2006-01-02", season.Start)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end := time.Now()
+		if season.End != "" {
+			end, err = time.Parse("2006-01-02", season.End)
+			if err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+			end = end.AddDate(0, 0, 1)
+		}
+		return start, end, nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown season %s", name)
+}
+
+// Leaderboard ranks the enabled members by a stat between the start and end date. The stat is the path of a
+// PlayerStats field, such as PvP.Kills or PvE.WeaponKills.Sniper, a derived stats progress metric such as PvP.KD, or
+// one of the stats derived from the stored activities. Adding /hour ranks by the rate per hour played instead.
+// Categories limit the activities the derived stats count, while a PlayerStats field must be in the PvE or PvP
+// category given. Derived stats leave out deleted characters unless they are included, PlayerStats totals always merge
+// all characters.
+//
+// PlayerStats values are all time totals, so with a start date the change between the last complete batch before the
+// start and the last complete batch before the end is ranked, which fails when no complete batch precedes the start.
+// Without a start date the totals themselves are ranked.
+//
+// The previous batch is the complete batch directly before the end batch. Previous ranks rank the same change up to
+// that batch, or for derived stats the activities from the start date up to its start time. Members get no previous
+// rank when the previous batch is not after the start batch or start date.
+func Leaderboard(stat string, startDate time.Time, endDate time.Time, categories []string, includeDeleted bool) ([]LeaderboardEntry, error) {
+	if endDate.IsZero() {
+		endDate = time.Now()
+	}
+	perHour := strings.HasSuffix(stat, perHourSuffix)
+	stat = strings.TrimSuffix(stat, perHourSuffix)
+
+	var modes []DestinyActivityModeType
+	var err error
+	if IsActivityStat(stat) {
+		modes, err = CategoryModes(categories)
+	} else {
+		var prefixes []string
+		prefixes, err = statCategoryPrefixes(categories)
+		if err == nil && !hasPrefix(stat, prefixes) {
+			err = fmt.Errorf("stat %s is not in categories %s", stat, strings.Join(categories, ", "))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	collectionMembers := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Members")
+	var dbPlayers []Player
+	err = collectionMembers.Find(bson.M{"Enabled": true}).All(&dbPlayers)
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := StatsBatches(BatchPlayerStats, false)
+	if err != nil {
+		return nil, err
+	}
+	var startBatch, endBatch, previousBatch *StatsBatch
+	for i := range batches {
+		if !startDate.IsZero() && !batches[i].StartTime.After(startDate) {
+			startBatch = &batches[i]
+		}
+		if !batches[i].StartTime.After(endDate) {
+			previousBatch = endBatch
+			endBatch = &batches[i]
+		}
+	}
+
+	var current, previous map[string]float64
+	if IsActivityStat(stat) {
+		var excludedCharacters []string
+		if !includeDeleted {
+			excludedCharacters, err = DeletedCharacterIDs()
+			if err != nil {
+				return nil, err
+			}
+		}
+		current, err = ActivityStatValues(stat, dbPlayers, startDate, endDate, perHour, modes, excludedCharacters)
+		if err == nil && previousBatch != nil && previousBatch.StartTime.After(startDate) {
+			previous, err = ActivityStatValues(stat, dbPlayers, startDate, previousBatch.StartTime, perHour, modes, excludedCharacters)
+		}
+	} else {
+		if endBatch == nil {
+			return nil, fmt.Errorf("no complete stats batch before %s", endDate.Format("2006-01-02"))
+		}
+		if !startDate.IsZero() && startBatch == nil {
+			return nil, fmt.Errorf("no complete stats batch before %s", startDate.Format("2006-01-02"))
+		}
+		current, err = PlayerStatValues(stat, startBatch, endBatch, perHour)
+		if err == nil && previousBatch != nil && (startBatch == nil || previousBatch.BatchID > startBatch.BatchID) {
+			previous, err = PlayerStatValues(stat, startBatch, previousBatch, perHour)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := RankValues(dbPlayers, current)
+	previousRanks := map[string]int{}
+	for _, entry := range RankValues(dbPlayers, previous) {
+		previousRanks[entry.MembershipID] = entry.Rank
+	}
+	for i := range entries {
+		entries[i].PreviousRank = previousRanks[entries[i].MembershipID]
+	}
+
+	return entries, nil
+}
+
+// RankValues ranks the players that have a value, highest first. Players with equal values share a rank.
+func RankValues(players []Player, values map[string]float64) []LeaderboardEntry {
+	entries := []LeaderboardEntry{}
+	for _, player := range players {
+		if value, ok := values[player.MembershipID]; ok {
+			entries = append(entries, LeaderboardEntry{MembershipID: player.MembershipID, DisplayName: player.DisplayName, Value: value})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Value == entries[j].Value {
+			return strings.ToLower(entries[i].DisplayName) < strings.ToLower(entries[j].DisplayName)
+		}
+		return entries[i].Value > entries[j].Value
+	})
+
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+
+	return entries
+}
+
+// IsActivityStat reports whether a leaderboard stat is derived from the stored activities
+func IsActivityStat(stat string) bool {
+	switch strings.ToLower(stat) {
+	case StatRaidClears, StatDungeonClears, StatFlawless, StatPrecisionRatio:
+		return true
+	}
+
+	return false
+}
+
+// PlayerStatValues returns the value of a stat in the end batch by membership ID, less the value in the start batch
+// when one is given. Rates per hour divide by the seconds played in PvE or PvP, following the stat's prefix.
+func PlayerStatValues(stat string, startBatch *StatsBatch, endBatch *StatsBatch, perHour bool) (map[string]float64, error) {
+	collectionStats := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("PlayerStats")
+
+	seconds := "PvE.SecondsPlayed"
+	if strings.HasPrefix(strings.ToLower(stat), "pvp.") {
+		seconds = "PvP.SecondsPlayed"
+	}
+
+	var endStats []PlayerStats
+	err := collectionStats.Find(bson.M{"BatchID": endBatch.BatchID}).All(&endStats)
+	if err != nil {
+		return nil, err
+	}
+	startStats := map[string]PlayerStats{}
+	if startBatch != nil {
+		var stats []PlayerStats
+		err = collectionStats.Find(bson.M{"BatchID": startBatch.BatchID}).All(&stats)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			startStats[s.MembershipID] = s
+		}
+	}
+
+	values := map[string]float64{}
+	for _, stats := range endStats {
+		value, ok := PlayerStatValue(stats, stat)
+		if !ok {
+			return nil, fmt.Errorf("unknown stat %s", stat)
+		}
+		played, _ := PlayerStatValue(stats, seconds)
+
+		if startBatch != nil {
+			before, ok := startStats[stats.MembershipID]
+			if !ok {
+				continue
+			}
+			beforeValue, _ := PlayerStatValue(before, stat)
+			beforePlayed, _ := PlayerStatValue(before, seconds)
+			value -= beforeValue
+			played -= beforePlayed
+		}
+
+		if perHour {
+			if played <= 0 {
+				continue
+			}
+			value = value / (played / 3600)
+		}
+		values[stats.MembershipID] = value
+	}
+
+	return values, nil
+}
+
+// PlayerStatValue returns a stat of a PlayerStats snapshot. Stats progress metrics are looked up by name first, after
+// which the stat is taken as a path of PlayerStats field names, ignoring case.
+func PlayerStatValue(stats PlayerStats, stat string) (float64, bool) {
+	for _, metric := range statMetrics() {
+		if strings.EqualFold(metric.Name, stat) {
+			return metric.Value(stats), true
+		}
+	}
+
+	value := reflect.ValueOf(stats)
+	for _, name := range strings.Split(stat, ".") {
+		if value.Kind() != reflect.Struct {
+			return 0, false
+		}
+		value = value.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+		if !value.IsValid() {
+			return 0, false
+		}
+	}
+	if value.Kind() != reflect.Float64 {
+		return 0, false
+	}
+
+	return value.Float(), true
+}
+
+// ActivityStatValues derives a stat from the activities between the start and end date by membership ID. Clears are
+// fresh clears of raids or dungeons, flawless activities are fresh clears in which nobody died, and the precision
+// ratio is the share of kills that were precision kills. Rates per hour divide by the time played in the counted
+// activities. With category modes given, only activities in those modes count. Entries of the excluded characters are
+// ignored.
+func ActivityStatValues(stat string, players []Player, startDate time.Time, endDate time.Time, perHour bool, categoryModes []DestinyActivityModeType, excludedCharacters []string) (map[string]float64, error) {
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+
+	var modes []DestinyActivityModeType
+	switch strings.ToLower(stat) {
+	case StatRaidClears:
+		modes = DescendantModes([]DestinyActivityModeType{ModeRaid})
+	case StatDungeonClears:
+		modes = DescendantModes([]DestinyActivityModeType{ModeDungeon})
+	}
+
+	memberIDs := []string{}
+	for _, player := range players {
+		memberIDs = append(memberIDs, player.MembershipID)
+	}
+	query := ActivityQuery(startDate, endDate, modes)
+	query["Entries.Player.DestinyUserInfo.MembershipID"] = bson.M{"$in": memberIDs}
+	if len(categoryModes) > 0 {
+		query["$and"] = []bson.M{{"ActivityDetails.Modes": bson.M{"$in": categoryModes}}}
+	}
+
+	counts := map[string]float64{}
+	kills := map[string]float64{}
+	seconds := map[string]float64{}
+	var activity PGCR
+	iter := collectionActivities.Find(query).Iter()
+	for iter.Next(&activity) {
+		DropCharacterEntries(&activity, excludedCharacters)
+		deaths := 0.0
+		for _, entry := range activity.Entries {
+			deaths += entry.Values.Deaths.Basic.Value
+		}
+		kinds := RunKinds(activity)
+
+		counted := map[string]bool{}
+		for _, entry := range activity.Entries {
+			membershipID := entry.Player.DestinyUserInfo.MembershipID
+			if !ContainsString(memberIDs, membershipID) {
+				continue
+			}
+
+			switch strings.ToLower(stat) {
+			case StatRaidClears, StatDungeonClears:
+				if kinds[membershipID] != ClearFresh {
+					continue
+				}
+				if !counted[membershipID] {
+					counts[membershipID]++
+				}
+			case StatFlawless:
+				if kinds[membershipID] == ClearFresh && deaths == 0 && !counted[membershipID] {
+					counts[membershipID]++
+				}
+			case StatPrecisionRatio:
+				counts[membershipID] += entry.Extended.Values.PrecisionKills.Basic.Value
+				kills[membershipID] += entry.Values.Kills.Basic.Value
+			}
+			counted[membershipID] = true
+			seconds[membershipID] += entry.Values.TimePlayedSeconds.Basic.Value
+		}
+		activity = PGCR{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	values := map[string]float64{}
+	for membershipID, played := range seconds {
+		value := counts[membershipID]
+		if strings.ToLower(stat) == StatPrecisionRatio {
+			if kills[membershipID] == 0 {
+				continue
+			}
+			value = value / kills[membershipID]
+		}
+		if perHour {
+			if played <= 0 {
+				continue
+			}
+			value = value / (played / 3600)
+		}
+		values[membershipID] = value
+	}
+
+	return values, nil
+}
+
+// EntryCleared reports whether a player completed an activity by finishing its objective
+func EntryCleared(completed float64, completionReason float64) bool {
+	return completed == 1 && completionReason == 0
+}
+
+// WriteLeaderboard prints a leaderboard and writes it to a TSV file
+func WriteLeaderboard(entries []LeaderboardEntry, stat string, postfix string, top int) error {
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Leaderboard: %s\r\n", stat)
+	f.WriteString("rank\tchange\tplayer\tmembershipId\tvalue\r\n")
+	for _, entry := range entries {
+		if top > 0 && entry.Rank > top {
+			break
+		}
+
+		change := ""
+		if entry.PreviousRank > 0 {
+			change = fmt.Sprintf("%+d", entry.PreviousRank-entry.Rank)
+		}
+		line := fmt.Sprintf("%d\t%s\t%s\t%s\t%s", entry.Rank, change, entry.DisplayName, entry.MembershipID, formatDelta(entry.Value))
+		fmt.Printf("%s\r\n", line)
+		f.WriteString(line + "\r\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankValues(t *testing.T) {
+	players := []Player{
+		{MembershipID: "1", DisplayName: "bravo"},
+		{MembershipID: "2", DisplayName: "Alpha"},
+		{MembershipID: "3", DisplayName: "Charlie"},
+		{MembershipID: "4", DisplayName: "Delta"},
+	}
+	tests := []struct {
+		name   string
+		values map[string]float64
+		want   []LeaderboardEntry
+	}{
+		{
+			name:   "no values",
+			values: map[string]float64{},
+			want:   []LeaderboardEntry{},
+		},
+		{
+			name:   "highest first",
+			values: map[string]float64{"1": 5, "2": 10, "3": 7.5},
+			want: []LeaderboardEntry{
+				{Rank: 1, MembershipID: "2", DisplayName: "Alpha", Value: 10},
+				{Rank: 2, MembershipID: "3", DisplayName: "Charlie", Value: 7.5},
+				{Rank: 3, MembershipID: "1", DisplayName: "bravo", Value: 5},
+			},
+		},
+		{
+			name:   "equal values share a rank ordered by name",
+			values: map[string]float64{"1": 3, "2": 3, "3": 1, "4": 0},
+			want: []LeaderboardEntry{
+				{Rank: 1, MembershipID: "2", DisplayName: "Alpha", Value: 3},
+				{Rank: 1, MembershipID: "1", DisplayName: "bravo", Value: 3},
+				{Rank: 3, MembershipID: "3", DisplayName: "Charlie", Value: 1},
+				{Rank: 4, MembershipID: "4", DisplayName: "Delta", Value: 0},
+			},
+		},
+		{
+			name:   "values of other players are left out",
+			values: map[string]float64{"4": 2, "9": 8},
+			want:   []LeaderboardEntry{{Rank: 1, MembershipID: "4", DisplayName: "Delta", Value: 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := RankValues(players, test.values)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("RankValues() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
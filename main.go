@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	mgo "gopkg.in/mgo.v2"
@@ -11,6 +12,7 @@ import (
 var (
 	config       Configuration
 	mongoSession *mgo.Session
+	definitions  *DefinitionStore
 )
 
 func main() {
@@ -31,24 +33,72 @@ func main() {
 
 	fmt.Printf("%s | ClanInspector started\r\n", time.Now().Format("2006-01-02 15:04:05"))
 
+	// Load the Destiny manifest if configured, reports fall back to showing hashes without it
+	if config.ManifestPath != "" || config.ManifestURL != "" {
+		err = LoadManifest()
+		if err != nil {
+			fmt.Printf("Error loading manifest, reports show hashes instead of names: %s\r\n", err.Error())
+		}
+	}
+
+	// Run the command named on the command line, if any, instead of the default retrieval
+	if len(os.Args) > 1 {
+		RunCommand(os.Args[1:])
+		return
+	}
+
 	RetrieveMembers()
 	//RetrieveActivities()
 	//RetrievePlayersStats()
 	//RetrievePlayersAggregateStats()
 
 	//FixActivities()
+	//ResolveStoredCharacterTypes()
 
 	//WhoPlaysWithWho(
 	//	time.Date(2018, time.May, 12, 0, 0, 0, 0, time.UTC),
 	//	time.Date(2019, time.June, 12, 0, 0, 0, 0, time.UTC),
 	//	time.Now().Format("060102"),
 	//	true,
+	//	false,
+	//	[]string{"Raid"},
+	//	CoPlayFireteam,
+	//	WeightTimePlayed,
+	//	false,
+	//	GraphJSON,
+	//)
+
+	//GraphEvolution(
+	//	time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+	//	time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC),
+	//	time.Now().Format("060102")+"_timeline",
+	//	WindowMonthly,
+	//	false,
+	//	false,
+	//	[]string{},
+	//	CoPlayFireteam,
+	//	WeightTimePlayed,
+	//	false,
+	//	GraphGEXF,
 	//)
 
 	//WhoPlaysWhen(
 	//	time.Date(2017, time.April,.y.yb,b, ,/, ,h,,/,, , r  dt=0-]5 1, 0, 0, 0, 0, time.UTC),
 	//	time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC),
 	//	time.Now().Format("060102"),
+	//	false,
+	//	[]string{},
+	//	true,
+	//	"",
+	//	30,
+	//)
+
+	//MostUsedWeapons(
+	//	time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC),
+	//	time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC),
+	//	time.Now().Format("060102")+"_weapons",
+	//	[]string{"Raid", "Dungeon"},
+	//	false,
 	//)
 
 	//TestActivity("1676662159")
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type destinyManifest struct {
+	Response struct {
+		Version                  string            `json:"version"`
+		MobileWorldContentPaths  map[string]string `json:"mobileWorldContentPaths"`
+		JSONWorldContentPaths    map[string]string `json:"jsonWorldContentPaths"`
+		MobileClanBannerDatabase string            `json:"mobileClanBannerDatabasePath"`
+	} `json:"Response"`
+	ErrorCode       int    `json:"ErrorCode"`
+	ThrottleSeconds int    `json:"ThrottleSeconds"`
+	ErrorStatus     string `json:"ErrorStatus"`
+	Message         string `json:"Message"`
+	MessageData     struct {
+	} `json:"MessageData"`
+}
+
+type displayProperties struct {
+	Description string `json:"description" bson:"Description,omitempty"`
+	Name        string `json:"name" bson:"Name,omitempty"`
+	Icon        string `json:"icon" bson:"Icon,omitempty"`
+	HasIcon     bool   `json:"hasIcon" bson:"HasIcon,omitempty"`
+}
+
+type ItemDefinition struct {
+	DisplayProperties   displayProperties `json:"displayProperties"`
+	ItemTypeDisplayName string            `json:"itemTypeDisplayName"`
+	ItemType            int               `json:"itemType"`
+	ItemSubType         int               `json:"itemSubType"`
+	Inventory           struct {
+		TierTypeName string `json:"tierTypeName"`
+		TierType     int    `json:"tierType"`
+	} `json:"inventory"`
+	Hash int64 `json:"hash"`
+}
+
+type ClassDefinition struct {
+	DisplayProperties displayProperties `json:"displayProperties"`
+	ClassType         int               `json:"classType"`
+	Hash              int64             `json:"hash"`
+}
+
+type RaceDefinition struct {
+	DisplayProperties displayProperties `json:"displayProperties"`
+	RaceType          int               `json:"raceType"`
+	Hash              int64             `json:"hash"`
+}
+
+type GenderDefinition struct {
+	DisplayProperties displayProperties `json:"displayProperties"`
+	GenderType        int               `json:"genderType"`
+	Hash              int64             `json:"hash"`
+}
+
+type DestinationDefinition struct {
+	DisplayProperties displayProperties `json:"displayProperties"`
+	PlaceHash         int64             `json:"placeHash"`
+	Hash              int64             `json:"hash"`
+}
+
+type ActivityModeDefinition struct {
+	DisplayProperties    displayProperties `json:"displayProperties"`
+	ModeType             int               `json:"modeType"`
+	ActivityModeCategory int               `json:"activityModeCategory"`
+	IsTeamBased          bool              `json:"isTeamBased"`
+	FriendlyName         string            `json:"friendlyName"`
+	ParentHashes         []int64           `json:"parentHashes"`
+	Hash                 int64             `json:"hash"`
+}
+
+// DefinitionStore holds the Destiny manifest definitions used to resolve the hashes stored in activities and characters
+type DefinitionStore struct {
+	Activities    map[int64]HashedActivityDetails
+	Items         map[int64]ItemDefinition
+	Classes       map[int64]ClassDefinition
+	Races         map[int64]RaceDefinition
+	Genders       map[int64]GenderDefinition
+	Destinations  map[int64]DestinationDefinition
+	ActivityModes map[int64]ActivityModeDefinition
+	ClassHashes   map[DestinyClass]int64
+	RaceHashes    map[DestinyRace]int64
+	GenderHashes  map[DestinyGender]int64
+}
+
+// manifestTables lists the manifest tables loaded into the DefinitionStore
+var manifestTables = []string{
+	"DestinyActivityDefinition",
+	"DestinyInventoryItemDefinition",
+	"DestinyClassDefinition",
+	"DestinyRaceDefinition",
+	"DestinyGenderDefinition",
+	"DestinyDestinationDefinition",
+	"DestinyActivityModeDefinition",
+}
+
+// loadSQLiteManifest loads the definitions from a SQLite manifest database. It is replaced when built with the
+// sqlite tag, as the SQLite driver requires cgo which the cross compiled builds do not have.
+var loadSQLiteManifest = func(path string, store *DefinitionStore) error {
+	return errors.New("SQLite manifests are not supported in this build, build with -tags sqlite or use the JSON manifest")
+}
+
+// NewDefinitionStore returns an empty DefinitionStore
+func NewDefinitionStore() *DefinitionStore {
+	return &DefinitionStore{
+		Activities:    map[int64]HashedActivityDetails{},
+		Items:         map[int64]ItemDefinition{},
+		Classes:       map[int64]ClassDefinition{},
+		Races:         map[int64]RaceDefinition{},
+		Genders:       map[int64]GenderDefinition{},
+		Destinations:  map[int64]DestinationDefinition{},
+		ActivityModes: map[int64]ActivityModeDefinition{},
+		ClassHashes:   map[DestinyClass]int64{},
+		RaceHashes:    map[DestinyRace]int64{},
+		GenderHashes:  map[DestinyGender]int64{},
+	}
+}
+
+// LoadManifest loads the Destiny manifest into the global definitions store. The manifest is read from ManifestPath if
+// that file is up to date, otherwise it is downloaded from ManifestURL, or from the location published by the Bungie
+// API if no URL is configured. Downloaded content is saved to ManifestPath so that later runs can use the local copy,
+// with the location it was downloaded from next to it. The local copy is up to date while that location matches
+// ManifestURL or, without a configured URL, the location currently published, which changes with every manifest
+// version. A local copy without a recorded location is replaced.
+func LoadManifest() error {
+	store := NewDefinitionStore()
+
+	manifestURL := config.ManifestURL
+	if manifestURL == "" {
+		var err error
+		manifestURL, err = GetManifestURL()
+		if err != nil {
+			return fmt.Errorf("finding manifest: %s", err.Error())
+		}
+	}
+
+	if config.ManifestPath != "" {
+		version, err := ioutil.ReadFile(manifestVersionPath())
+		if _, statErr := os.Stat(config.ManifestPath); statErr == nil && err == nil && string(version) == manifestURL {
+			err = store.LoadFile(config.ManifestPath)
+			if err != nil {
+				return fmt.Errorf("loading manifest %s: %s", config.ManifestPath, err.Error())
+			}
+			definitions = store
+			return nil
+		}
+	}
+
+	fmt.Printf("Downloading manifest from %s\r\n", manifestURL)
+	data, err := DownloadManifest(manifestURL)
+	if err != nil {
+		return fmt.Errorf("downloading manifest: %s", err.Error())
+	}
+
+	path := config.ManifestPath
+	if path == "" {
+		f, err := ioutil.TempFile("", "ClanInspectorManifest")
+		if err != nil {
+			return fmt.Errorf("creating manifest file: %s", err.Error())
+		}
+		f.Close()
+		path = f.Name()
+		defer os.Remove(path)
+	}
+
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("saving manifest: %s", err.Error())
+	}
+	if config.ManifestPath != "" {
+		err = ioutil.WriteFile(manifestVersionPath(), []byte(manifestURL), 0644)
+		if err != nil {
+			return fmt.Errorf("saving manifest version: %s", err.Error())
+		}
+	}
+
+	err = store.LoadFile(path)
+	if err != nil {
+		return fmt.Errorf("loading manifest: %s", err.Error())
+	}
+
+	definitions = store
+	return nil
+}
+
+// manifestVersionPath returns the path of the file recording where the manifest at ManifestPath was downloaded from
+func manifestVersionPath() string {
+	return config.ManifestPath + ".version"
+}
+
+// GetManifestURL returns the location of the JSON world content for the configured locale
+func GetManifestURL() (string, error) {
+	req, err := http.NewRequest("GET", "https://bungie.net/Platform/Destiny2/Manifest/", nil)
+	req.Header.Add("x-api-key", config.APIKey)
+	if err != nil {
+		log.Fatal("NewRequest: ", err)
+		return "", err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal("Do: ", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Body: ", err)
+		return "", err
+	}
+
+	// Fill the record with the data from the JSON
+	var record destinyManifest
+	if err := json.Unmarshal([]byte(string(body)), &record); err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		return "", err
+	}
+
+	if record.ErrorCode != 1 {
+		return "", errors.New(record.Message)
+	}
+
+	path, ok := record.Response.JSONWorldContentPaths[ManifestLocale()]
+	if !ok {
+		return "", fmt.Errorf("no manifest found for locale %s", ManifestLocale())
+	}
+
+	return "https://www.bungie.net" + path, nil
+}
+
+// DownloadManifest downloads manifest content, unpacking it if it is zipped as the SQLite content is
+func DownloadManifest(manifestURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", manifestURL, nil)
+	req.Header.Add("x-api-key", config.APIKey)
+	if err != nil {
+		log.Fatal("NewRequest: ", err)
+		return nil, err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal("Do: ", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Body: ", err)
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(body, []byte("PK")) {
+		return body, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	if len(archive.File) == 0 {
+		return nil, errors.New("manifest archive is empty")
+	}
+
+	content, err := archive.File[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return ioutil.ReadAll(content)
+}
+
+// ManifestLocale returns the configured manifest locale, defaulting to English
+func ManifestLocale() string {
+	if config.ManifestLocale == "" {
+		return "en"
+	}
+
+	return config.ManifestLocale
+}
+
+// LoadFile loads the definitions from a manifest file, which can be either the JSON world content or a SQLite database
+func (store *DefinitionStore) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	n, _ := f.Read(header)
+	f.Close()
+
+	if strings.HasPrefix(string(header[:n]), "SQLite format 3") {
+		return loadSQLiteManifest(path, store)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return store.LoadJSON(data)
+}
+
+// LoadJSON loads the definitions from the JSON world content
+func (store *DefinitionStore) LoadJSON(data []byte) error {
+	var content map[string]map[string]json.RawMessage
+	err := json.Unmarshal(data, &content)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range manifestTables {
+		for _, definition := range content[table] {
+			err = store.Add(table, definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Add adds a single definition from the given manifest table to the store
+func (store *DefinitionStore) Add(table string, data []byte) error {
+	switch table {
+	case "DestinyActivityDefinition":
+		var definition HashedActivityDetails
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Activities[definition.Hash] = definition
+	case "DestinyInventoryItemDefinition":
+		var definition ItemDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Items[definition.Hash] = definition
+	case "DestinyClassDefinition":
+		var definition ClassDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Classes[definition.Hash] = definition
+		if definition.DisplayProperties.Name != "" {
+			store.ClassHashes[DestinyClass(definition.ClassType)] = definition.Hash
+		}
+	case "DestinyRaceDefinition":
+		var definition RaceDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Races[definition.Hash] = definition
+		if definition.DisplayProperties.Name != "" {
+			store.RaceHashes[DestinyRace(definition.RaceType)] = definition.Hash
+		}
+	case "DestinyGenderDefinition":
+		var definition GenderDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Genders[definition.Hash] = definition
+		if definition.DisplayProperties.Name != "" {
+			store.GenderHashes[DestinyGender(definition.GenderType)] = definition.Hash
+		}
+	case "DestinyDestinationDefinition":
+		var definition DestinationDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.Destinations[definition.Hash] = definition
+	case "DestinyActivityModeDefinition":
+		var definition ActivityModeDefinition
+		if err := json.Unmarshal(data, &definition); err != nil {
+			return err
+		}
+		store.ActivityModes[definition.Hash] = definition
+	}
+
+	return nil
+}
+
+// ActivityName returns the name of an activity, or its hash when the manifest has no name for it
+func ActivityName(hash int64) string {
+	if definitions != nil {
+		if definition, ok := definitions.Activities[hash]; ok && definition.DisplayProperties.Name != "" {
+			return definition.DisplayProperties.Name
+		}
+	}
+
+	return fmt.Sprintf("%d", hash)
+}
+
+// ItemName returns the name of an inventory item, or its hash when the manifest has no name for it
+func ItemName(hash int64) string {
+	if definitions != nil {
+		if definition, ok := definitions.Items[hash]; ok && definition.DisplayProperties.Name != "" {
+			return definition.DisplayProperties.Name
+		}
+	}
+
+	return fmt.Sprintf("%d", hash)
+}
+
+// ClassName returns the name of a class, or its hash when the manifest has no name for it
+func ClassName(hash int64) string {
+	if definitions != nil {
+		if definition, ok := definitions.Classes[hash]; ok && definition.DisplayProperties.Name != "" {
+			return definition.DisplayProperties.Name
+		}
+	}
+
+	return fmt.Sprintf("%d", hash)
+}
+
+// RaceName returns the name of a race, or its hash when the manifest has no name for it
+func RaceName(hash int64) string {
+	if definitions != nil {
+		if definition, ok := definitions.Races[hash]; ok && definition.DisplayProperties.Name != "" {
+			return definition.DisplayProperties.Name
+		}
+	}
+
+	return fmt.Sprintf("%d", hash)
+}
+
+// GenderName returns the name of a gender, or its hash when the manifest has no name for it
+func GenderName(hash int64) string {
+	if definitions != nil {
+		if definition, ok := definitions.Genders[hash]; ok && definition.DisplayProperties.Name != "" {
+			return definition.DisplayProperties.Name
+		}
+	}
+
+	return fmt.Sprintf("%d", hash)
+}
@@ -0,0 +1,52 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	loadSQLiteManifest = loadSQLiteDefinitions
+}
+
+// loadSQLiteDefinitions loads the definitions from the SQLite manifest database, in which every table holds the JSON
+// of its definitions keyed by the signed 32 bit hash
+func loadSQLiteDefinitions(path string, store *DefinitionStore) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, table := range manifestTables {
+		rows, err := db.Query(fmt.Sprintf("SELECT json FROM %s", table))
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var data []byte
+			if err := rows.Scan(&data); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := store.Add(table, data); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -42,12 +45,72 @@ type clanMembers struct {
 	} `json:"MessageData"`
 }
 
+type userMemberships struct {
+	Response struct {
+		DestinyMemberships  []Player `json:"destinyMemberships"`
+		PrimaryMembershipID string   `json:"primaryMembershipId"`
+	} `json:"Response"`
+	ErrorCode       int    `json:"ErrorCode"`
+	ThrottleSeconds int    `json:"ThrottleSeconds"`
+	ErrorStatus     string `json:"ErrorStatus"`
+	Message         string `json:"Message"`
+	MessageData     struct {
+	} `json:"MessageData"`
+}
+
 type Player struct {
-	IconPath       string `json:"iconPath" bson:"IconPath"`
-	MembershipType int    `json:"membershipType" bson:"MembershipType"`
-	MembershipID   string `json:"membershipId" bson:"MembershipID"`
-	DisplayName    string `json:"displayName" bson:"DisplayName"`
-	Enabled        bool   `json:"enabled" bson:"Enabled"`
+	IconPath                  string    `json:"iconPath" bson:"IconPath"`
+	MembershipType            int       `json:"membershipType" bson:"MembershipType"`
+	MembershipID              string    `json:"membershipId" bson:"MembershipID"`
+	DisplayName               string    `json:"displayName" bson:"DisplayName"`
+	CrossSaveOverride         int       `json:"crossSaveOverride" bson:"CrossSaveOverride,omitempty"`
+	ApplicableMembershipTypes []int     `json:"applicableMembershipTypes" bson:"ApplicableMembershipTypes,omitempty"`
+	Enabled                   bool      `json:"enabled" bson:"Enabled"`
+	JoinDate                  time.Time `json:"-" bson:"JoinDate,omitempty"`
+	ClanRole                  ClanRole  `json:"-" bson:"ClanRole,omitempty"`
+	// LinkedMembershipIDs holds the IDs of all accounts linked by cross save, as found when resolving the primary
+	// account. It is not stored.
+	LinkedMembershipIDs []string `json:"-" bson:"-"`
+}
+
+// ClanRole is the rank of a member within the clan, matching the Bungie RuntimeGroupMemberType
+type ClanRole int
+
+const (
+	RoleNone          ClanRole = 0
+	RoleBeginner      ClanRole = 1
+	RoleMember        ClanRole = 2
+	RoleAdmin         ClanRole = 3
+	RoleActingFounder ClanRole = 4
+	RoleFounder       ClanRole = 5
+)
+
+var clanRoleNames = map[ClanRole]string{
+	RoleNone:          "None",
+	RoleBeginner:      "Beginner",
+	RoleMember:        "Member",
+	RoleAdmin:         "Admin",
+	RoleActingFounder: "ActingFounder",
+	RoleFounder:       "Founder",
+}
+
+func (role ClanRole) String() string {
+	if name, ok := clanRoleNames[role]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Role %d", int(role))
+}
+
+// LookupClanRole finds a clan role by name, ignoring case
+func LookupClanRole(name string) (ClanRole, bool) {
+	for role, roleName := range clanRoleNames {
+		if strings.EqualFold(roleName, name) {
+			return role, true
+		}
+	}
+
+	return RoleNone, false
 }
 
 func GetMembers() []Player {
@@ -82,8 +145,80 @@ func GetMembers() []Player {
 	players := []Player{}
 	for _, member := range record.Response.Results {
 		//fmt.Printf("%s\r\n", member.DestinyUserInfo.DisplayName)
-		players = append(players, member.DestinyUserInfo)
+		player, err := GetPrimaryMembership(member.DestinyUserInfo)
+		if err != nil {
+			log.Printf("Error resolving cross save account for %s: %v", member.DestinyUserInfo.DisplayName, err)
+			player = member.DestinyUserInfo
+		}
+		player.JoinDate = member.JoinDate
+		player.ClanRole = ClanRole(member.MemberType)
+		players = append(players, player)
 	}
 
 	return players
 }
+
+// GetPrimaryMembership returns the cross save primary account for a player, along with the IDs of all its linked
+// accounts. The accounts are looked up even when the roster entry already is the primary account, as the member may
+// have been stored under another of them. Players that have not enabled cross save are returned unchanged.
+func GetPrimaryMembership(player Player) (Player, error) {
+	if player.CrossSaveOverride == 0 {
+		return player, nil
+	}
+
+	url := fmt.Sprintf("https://bungie.net/Platform/User/GetMembershipsById/%s/%d/", url.QueryEscape(player.MembershipID), player.MembershipType)
+	req, err := http.NewRequest("GET", url, nil)
+	req.Header.Add("x-api-key", config.APIKey)
+	if err != nil {
+		log.Fatal("NewRequest: ", err)
+		return player, err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal("Do: ", err)
+		return player, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Body: ", err)
+		return player, err
+	}
+
+	// Fill the record with the data from the JSON
+	var record userMemberships
+	if err := json.Unmarshal([]byte(string(body)), &record); err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		return player, err
+	}
+
+	if record.ErrorCode != 1 {
+		return player, errors.New(record.Message)
+	}
+
+	linkedIDs := []string{}
+	for _, membership := range record.Response.DestinyMemberships {
+		linkedIDs = append(linkedIDs, membership.MembershipID)
+	}
+	for _, membership := range record.Response.DestinyMemberships {
+		if membership.MembershipID == record.Response.PrimaryMembershipID || membership.MembershipType == player.CrossSaveOverride {
+			membership.LinkedMembershipIDs = linkedIDs
+			return membership, nil
+		}
+	}
+
+	return player, nil
+}
+
+// MembershipTypeFor returns the membership type to use in API calls for a player or character,
+// falling back to the configured MembershipType for records stored before it was tracked.
+func MembershipTypeFor(membershipType int) string {
+	if membershipType == 0 {
+		return config.MembershipType
+	}
+
+	return strconv.Itoa(membershipType)
+}
@@ -487,6 +487,25 @@ type PlayerStats struct {
 	PvP          PvpStats  `bson:"PvP,omitempty"`
 }
 
+// CharacterActivityStats holds the per activity totals of a single character, as stored per stats batch
+type CharacterActivityStats struct {
+	BatchID      int              `bson:"BatchID"`
+	BatchTime    time.Time        `bson:"BatchTime"`
+	MembershipID string           `bson:"MembershipID"`
+	MemberName   string           `bson:"MemberName"`
+	CharacterID  string           `bson:"CharacterID"`
+	Activities   []ActivityTotals `bson:"Activities"`
+}
+
+type ActivityTotals struct {
+	ActivityHash        int64   `bson:"ActivityHash"`
+	Completions         float64 `bson:"Completions,omitempty"`
+	Kills               float64 `bson:"Kills,omitempty"`
+	Deaths              float64 `bson:"Deaths,omitempty"`
+	FastestCompletionMs float64 `bson:"FastestCompletionMs,omitempty"`
+	SecondsPlayed       float64 `bson:"SecondsPlayed,omitempty"`
+}
+
 type WeaponStats struct {
 	AutoRifle       float64 `bson:"AutoRifle,omitempty"`
 	BeamRifle       float64 `bson:"BeamRifle,omitempty"`
@@ -554,8 +573,8 @@ type PvpStats struct {
 	LongestKillSpree       float64     `bson:"LongestKillSpree,omitempty"`
 }
 
-func GetMemberStats(memberID string) (*MemberStats, error) {
-	url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Account/%s/Stats", config.MembershipType, url.QueryEscape(memberID))
+func GetMemberStats(membershipType int, memberID string) (*MemberStats, error) {
+	url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Account/%s/Stats", MembershipTypeFor(membershipType), url.QueryEscape(memberID))
 	req, err := http.NewRequest("GET", url, nil)
 	req.Header.Add("x-api-key", config.APIKey)
 	if err != nil {
@@ -590,3 +609,40 @@ func GetMemberStats(memberID string) (*MemberStats, error) {
 
 	return &record.Response, nil
 }
+
+func GetCharacterAggregateStats(membershipType int, memberID string, characterID string) (*CharacterAgregateStats, error) {
+	url := fmt.Sprintf("https://bungie.net/Platform/Destiny2/%s/Account/%s/Character/%s/Stats/AggregateActivityStats/", MembershipTypeFor(membershipType), url.QueryEscape(memberID), url.QueryEscape(characterID))
+	req, err := http.NewRequest("GET", url, nil)
+	req.Header.Add("x-api-key", config.APIKey)
+	if err != nil {
+		log.Fatal("NewRequest: ", err)
+		return &CharacterAgregateStats{}, err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal("Do: ", err)
+		return &CharacterAgregateStats{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Body: ", err)
+		return &CharacterAgregateStats{}, err
+	}
+
+	// Fill the record with the data from the JSON
+	var record characterAggregateStats
+	if err := json.Unmarshal([]byte(string(body)), &record); err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		return &CharacterAgregateStats{}, err
+	}
+
+	if record.ErrorCode != 1 {
+		return &CharacterAgregateStats{}, errors.New(record.Message)
+	}
+
+	return &record.Response, nil
+}
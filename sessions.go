@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultSessionMaxGap is the maximum gap in minutes between two activities of the same session, when no SessionMaxGap
+// is configured
+const defaultSessionMaxGap = 30
+
+// Session is a chain of activities of a member with short gaps between them
+type Session struct {
+	MembershipID  string                    `bson:"MembershipID"`
+	Start         time.Time                 `bson:"Start"`
+	End           time.Time                 `bson:"End"`
+	Activities    int                       `bson:"Activities"`
+	PlayedSeconds float64                   `bson:"PlayedSeconds"`
+	MaxGap        int                       `bson:"MaxGap"`
+	Modes         []DestinyActivityModeType `bson:"Modes"`
+	Partners      []SessionPartner          `bson:"Partners"`
+}
+
+// SessionPartner is a clan member that played part of a session, with the number of activities and the time played
+// together
+type SessionPartner struct {
+	MembershipID  string  `bson:"MembershipID"`
+	Activities    int     `bson:"Activities"`
+	SharedSeconds float64 `bson:"SharedSeconds"`
+}
+
+// playInterval is the time a member spent in a single activity, and the time shared with each other member in it
+type playInterval struct {
+	start    time.Time
+	end      time.Time
+	played   float64
+	modes    []DestinyActivityModeType
+	partners map[string]float64
+}
+
+// SessionMaxGap returns the configured maximum gap between the activities of a session in minutes
+func SessionMaxGap() int {
+	if config.SessionMaxGap > 0 {
+		return config.SessionMaxGap
+	}
+
+	return defaultSessionMaxGap
+}
+
+// BuildSessions deletes and rebuilds the stored sessions of all enabled members from all their stored activities on
+// every run, rather than only adding the sessions of new activities. A new session starts whenever more than maxGap
+// minutes pass between the end of one activity and the start of the next. Activities of deleted characters are only
+// used when they are included.
+func BuildSessions(maxGap int, includeDeleted bool) error {
+	if maxGap <= 0 {
+		return fmt.Errorf("the maximum gap must be positive")
+	}
+
+	collectionActivities := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Activities")
+	collectionSessions := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Sessions")
+
+	dbPlayers, err := GroupMembers("clan")
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, player := range dbPlayers {
+		names[player.MembershipID] = player.DisplayName
+	}
+
+	var excludedCharacters []string
+	if !includeDeleted {
+		excludedCharacters, err = DeletedCharacterIDs()
+		if err != nil {
+			return err
+		}
+	}
+
+	total := 0
+	for _, player := range dbPlayers {
+		sessions, err := MemberSessions(collectionActivities, player.MembershipID, names, maxGap, excludedCharacters)
+		if err != nil {
+			return err
+		}
+
+		_, err = collectionSessions.RemoveAll(bson.M{"MembershipID": player.MembershipID})
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			err = collectionSessions.Insert(session)
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("%s: %d sessions\r\n", player.DisplayName, len(sessions))
+		total += len(sessions)
+	}
+
+	fmt.Printf("%d sessions of %d members\r\n", total, len(dbPlayers))
+	return nil
+}
+
+// MemberSessions groups the stored activities of a member into sessions. A member takes part in an activity from
+// StartSeconds after it began for TimePlayedSeconds, and shares it with other members for as long as they overlap.
+// Entries of the excluded characters are ignored, as are entries without any time played, so an activity the member
+// only loaded into does not open or extend a session.
+func MemberSessions(collectionActivities *mgo.Collection, membershipID string, names map[string]string, maxGap int, excludedCharacters []string) ([]Session, error) {
+	var activities []PGCR
+	err := collectionActivities.Find(MemberEntryQuery(membershipID, excludedCharacters)).Select(bson.M{
+		"Period":                1,
+		"ActivityDetails.Modes": 1,
+		"Entries.CharacterID":   1,
+		"Entries.Player.DestinyUserInfo.MembershipID": 1,
+		"Entries.Values.StartSeconds":                 1,
+		"Entries.Values.TimePlayedSeconds":            1,
+	}).All(&activities)
+	if err != nil {
+		return nil, err
+	}
+
+	intervals := []playInterval{}
+	for _, activity := range activities {
+		DropCharacterEntries(&activity, excludedCharacters)
+		if interval, ok := activityInterval(activity, membershipID, names); ok {
+			intervals = append(intervals, interval)
+		}
+	}
+
+	return groupSessions(membershipID, intervals, maxGap), nil
+}
+
+// activityInterval returns the time a member spent in an activity and the time shared with each other member in it,
+// or false when the member did not play any time in it
+func activityInterval(activity PGCR, membershipID string, names map[string]string) (playInterval, bool) {
+	starts := map[string]time.Time{}
+	ends := map[string]time.Time{}
+	played := 0.0
+	for _, entry := range activity.Entries {
+		playerID := entry.Player.DestinyUserInfo.MembershipID
+		if _, ok := names[playerID]; !ok && playerID != membershipID {
+			continue
+		}
+		if entry.Values.TimePlayedSeconds.Basic.Value <= 0 {
+			continue
+		}
+		start := activity.Period.Add(time.Duration(entry.Values.StartSeconds.Basic.Value) * time.Second)
+		end := start.Add(time.Duration(entry.Values.TimePlayedSeconds.Basic.Value) * time.Second)
+		if current, ok := starts[playerID]; !ok || start.Before(current) {
+			starts[playerID] = start
+		}
+		if end.After(ends[playerID]) {
+			ends[playerID] = end
+		}
+		if playerID == membershipID {
+			played += entry.Values.TimePlayedSeconds.Basic.Value
+		}
+	}
+
+	if _, ok := starts[membershipID]; !ok {
+		return playInterval{}, false
+	}
+
+	interval := playInterval{
+		start:    starts[membershipID],
+		end:      ends[membershipID],
+		played:   played,
+		modes:    activity.ActivityDetails.Modes,
+		partners: map[string]float64{},
+	}
+	for playerID, start := range starts {
+		if playerID == membershipID {
+			continue
+		}
+		if start.Before(interval.start) {
+			start = interval.start
+		}
+		end := ends[playerID]
+		if end.After(interval.end) {
+			end = interval.end
+		}
+		if end.After(start) {
+			interval.partners[playerID] = end.Sub(start).Seconds()
+		}
+	}
+
+	return interval, true
+}
+
+// groupSessions chains the play intervals of a member into sessions, starting a new session whenever more than maxGap
+// minutes pass between the end of the session so far and the start of the next interval
+func groupSessions(membershipID string, intervals []playInterval, maxGap int) []Session {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Before(intervals[j].start)
+	})
+
+	sessions := []Session{}
+	var session *Session
+	partners := map[string]*SessionPartner{}
+	for _, interval := range intervals {
+		if session == nil || interval.start.Sub(session.End) > time.Duration(maxGap)*time.Minute {
+			if session != nil {
+				sessions = append(sessions, closeSession(*session, partners))
+			}
+			session = &Session{MembershipID: membershipID, Start: interval.start, End: interval.end, MaxGap: maxGap, Modes: []DestinyActivityModeType{}}
+			partners = map[string]*SessionPartner{}
+		}
+
+		session.Activities++
+		session.PlayedSeconds += interval.played
+		for _, mode := range interval.modes {
+			if !ContainsMode(session.Modes, mode) {
+				session.Modes = append(session.Modes, mode)
+			}
+		}
+		if interval.end.After(session.End) {
+			session.End = interval.end
+		}
+		for playerID, shared := range interval.partners {
+			partner, ok := partners[playerID]
+			if !ok {
+				partner = &SessionPartner{MembershipID: playerID}
+				partners[playerID] = partner
+			}
+			partner.Activities++
+			partner.SharedSeconds += shared
+		}
+	}
+	if session != nil {
+		sessions = append(sessions, closeSession(*session, partners))
+	}
+
+	return sessions
+}
+
+// closeSession adds the partners to a session, most shared time first
+func closeSession(session Session, partners map[string]*SessionPartner) Session {
+	session.Partners = []SessionPartner{}
+	for _, partner := range partners {
+		session.Partners = append(session.Partners, *partner)
+	}
+	sort.Slice(session.Partners, func(i, j int) bool {
+		if session.Partners[i].SharedSeconds == session.Partners[j].SharedSeconds {
+			return session.Partners[i].MembershipID < session.Partners[j].MembershipID
+		}
+		return session.Partners[i].SharedSeconds > session.Partners[j].SharedSeconds
+	})
+
+	return session
+}
+
+// SessionReport writes stats on the stored sessions that started between the start and end date for every enabled
+// member: the number of sessions and sessions per week, their average, median and longest length, and the members
+// that shared the largest part of their playtime. A second file counts the session start times by weekday and hour in
+// the timezone of each member. A zero start date counts from the first stored session. With categories given, only
+// sessions that include an activity in those categories count, which needs sessions built since their modes were
+// stored.
+func SessionReport(startDate time.Time, endDate time.Time, postfix string, categories []string) error {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		return err
+	}
+
+	collectionSessions := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("Sessions")
+
+	dbPlayers, err := GroupMembers("clan")
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, player := range dbPlayers {
+		names[player.MembershipID] = player.DisplayName
+	}
+	sort.Slice(dbPlayers, func(i, j int) bool {
+		return strings.ToLower(dbPlayers[i].DisplayName) < strings.ToLower(dbPlayers[j].DisplayName)
+	})
+
+	timezones, err := LoadTimezones()
+	if err != nil {
+		return err
+	}
+
+	if startDate.IsZero() {
+		var first Session
+		err = collectionSessions.Find(nil).Sort("Start").One(&first)
+		if err == mgo.ErrNotFound {
+			return fmt.Errorf("no sessions stored, build them first")
+		}
+		if err != nil {
+			return err
+		}
+		startDate = first.Start
+	}
+	weeks := endDate.Sub(startDate).Hours() / (24 * 7)
+	if weeks < 1 {
+		weeks = 1
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	starts, err := os.Create(fmt.Sprintf("ClanInspector%s_%s_starts.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer starts.Close()
+
+	f.WriteString("player\tmembershipId\tsessions\tsessionsPerWeek\taverageMinutes\tmedianMinutes\tlongestMinutes\tactivitiesPerSession\ttopPartners\r\n")
+	starts.WriteString("player\ttimezone\tweekday\ttime\tvalue\r\n")
+	for _, player := range dbPlayers {
+		query := bson.M{
+			"MembershipID": player.MembershipID,
+			"Start":        bson.M{"$gte": startDate, "$lt": endDate},
+		}
+		if len(modes) > 0 {
+			query["Modes"] = bson.M{"$in": modes}
+		}
+		var sessions []Session
+		err = collectionSessions.Find(query).Sort("Start").All(&sessions)
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			continue
+		}
+
+		location := timezones.Location(player)
+		lengths := []float64{}
+		activities := 0
+		played := 0.0
+		shared := map[string]float64{}
+		startCounts := map[time.Weekday]map[int]int{}
+		for _, session := range sessions {
+			lengths = append(lengths, session.End.Sub(session.Start).Minutes())
+			activities += session.Activities
+			played += session.PlayedSeconds
+			for _, partner := range session.Partners {
+				shared[partner.MembershipID] += partner.SharedSeconds
+			}
+
+			local := session.Start.In(location)
+			if startCounts[local.Weekday()] == nil {
+				startCounts[local.Weekday()] = map[int]int{}
+			}
+			startCounts[local.Weekday()][local.Hour()]++
+		}
+
+		sort.Float64s(lengths)
+		total := 0.0
+		for _, length := range lengths {
+			total += length
+		}
+		median := lengths[len(lengths)/2]
+		if len(lengths)%2 == 0 {
+			median = (lengths[len(lengths)/2-1] + lengths[len(lengths)/2]) / 2
+		}
+
+		partnerIDs := []string{}
+		for partnerID := range shared {
+			partnerIDs = append(partnerIDs, partnerID)
+		}
+		sort.Slice(partnerIDs, func(i, j int) bool {
+			if shared[partnerIDs[i]] == shared[partnerIDs[j]] {
+				return names[partnerIDs[i]] < names[partnerIDs[j]]
+			}
+			return shared[partnerIDs[i]] > shared[partnerIDs[j]]
+		})
+		topPartners := []string{}
+		for _, partnerID := range partnerIDs {
+			if len(topPartners) == 3 {
+				break
+			}
+			if played > 0 {
+				topPartners = append(topPartners, fmt.Sprintf("%s (%.0f%%)", names[partnerID], shared[partnerID]/played*100))
+			}
+		}
+
+		f.WriteString(fmt.Sprintf("%s\t%s\t%d\t%.2f\t%.1f\t%.1f\t%.1f\t%.1f\t%s\r\n",
+			player.DisplayName, player.MembershipID, len(sessions), float64(len(sessions))/weeks, total/float64(len(lengths)),
+			median, lengths[len(lengths)-1], float64(activities)/float64(len(sessions)), strings.Join(topPartners, ", ")))
+
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			for hour := 0; hour < 24; hour++ {
+				if count := startCounts[weekday][hour]; count > 0 {
+					starts.WriteString(fmt.Sprintf("%s\t%s\t%s\t%02d:00\t%d\r\n", player.DisplayName, location.String(), weekday.String(), hour, count))
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestActivityInterval(t *testing.T) {
+	period := time.Date(2019, time.June, 3, 20, 0, 0, 0, time.UTC)
+	names := map[string]string{"1": "Member", "2": "Partner"}
+	tests := []struct {
+		name    string
+		entries []testEntry
+		want    playInterval
+		wantOK  bool
+	}{
+		{
+			name:    "member alone",
+			entries: []testEntry{{membershipID: "1", startSeconds: 60, playedSeconds: 600}},
+			want: playInterval{
+				start:    period.Add(time.Minute),
+				end:      period.Add(11 * time.Minute),
+				played:   600,
+				partners: map[string]float64{},
+			},
+			wantOK: true,
+		},
+		{
+			name: "shared time is limited to the time of the member",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 300, playedSeconds: 600},
+				{membershipID: "2", startSeconds: 0, playedSeconds: 1200},
+				{membershipID: "3", startSeconds: 0, playedSeconds: 1200},
+			},
+			want: playInterval{
+				start:    period.Add(5 * time.Minute),
+				end:      period.Add(15 * time.Minute),
+				played:   600,
+				partners: map[string]float64{"2": 600},
+			},
+			wantOK: true,
+		},
+		{
+			name: "partners without overlap are left out",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 0, playedSeconds: 300},
+				{membershipID: "2", startSeconds: 300, playedSeconds: 300},
+			},
+			want: playInterval{
+				start:    period,
+				end:      period.Add(5 * time.Minute),
+				played:   300,
+				partners: map[string]float64{},
+			},
+			wantOK: true,
+		},
+		{
+			name: "character swap",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 0, playedSeconds: 300},
+				{membershipID: "1", startSeconds: 600, playedSeconds: 300},
+			},
+			want: playInterval{
+				start:    period,
+				end:      period.Add(15 * time.Minute),
+				played:   600,
+				partners: map[string]float64{},
+			},
+			wantOK: true,
+		},
+		{
+			name: "entries without time played are skipped",
+			entries: []testEntry{
+				{membershipID: "1", startSeconds: 0, playedSeconds: 0},
+				{membershipID: "1", startSeconds: 120, playedSeconds: 300},
+				{membershipID: "2", startSeconds: 0, playedSeconds: 0},
+			},
+			want: playInterval{
+				start:    period.Add(2 * time.Minute),
+				end:      period.Add(7 * time.Minute),
+				played:   300,
+				partners: map[string]float64{},
+			},
+			wantOK: true,
+		},
+		{
+			name:    "member without time played",
+			entries: []testEntry{{membershipID: "1", startSeconds: 0, playedSeconds: 0}},
+			wantOK:  false,
+		},
+		{
+			name:    "member not in the activity",
+			entries: []testEntry{{membershipID: "2", startSeconds: 0, playedSeconds: 600}},
+			wantOK:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := activityInterval(testActivity(t, period, test.entries...), "1", names)
+			if ok != test.wantOK {
+				t.Fatalf("activityInterval() ok = %v, want %v", ok, test.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("activityInterval() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupSessions(t *testing.T) {
+	base := time.Date(2019, time.June, 3, 20, 0, 0, 0, time.UTC)
+	interval := func(start int, end int, partners map[string]float64, modes ...DestinyActivityModeType) playInterval {
+		return playInterval{
+			start:    base.Add(time.Duration(start) * time.Minute),
+			end:      base.Add(time.Duration(end) * time.Minute),
+			played:   float64(end-start) * 60,
+			modes:    modes,
+			partners: partners,
+		}
+	}
+	session := func(start int, end int, activities int, played float64, modes []DestinyActivityModeType, partners []SessionPartner) Session {
+		return Session{
+			MembershipID:  "1",
+			Start:         base.Add(time.Duration(start) * time.Minute),
+			End:           base.Add(time.Duration(end) * time.Minute),
+			Activities:    activities,
+			PlayedSeconds: played,
+			MaxGap:        30,
+			Modes:         modes,
+			Partners:      partners,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		intervals []playInterval
+		want      []Session
+	}{
+		{
+			name:      "no activities",
+			intervals: []playInterval{},
+			want:      []Session{},
+		},
+		{
+			name:      "gap of exactly the maximum",
+			intervals: []playInterval{interval(0, 10, nil), interval(40, 50, nil)},
+			want:      []Session{session(0, 50, 2, 1200, []DestinyActivityModeType{}, []SessionPartner{})},
+		},
+		{
+			name:      "gap over the maximum",
+			intervals: []playInterval{interval(0, 10, nil), interval(41, 50, nil)},
+			want: []Session{
+				session(0, 10, 1, 600, []DestinyActivityModeType{}, []SessionPartner{}),
+				session(41, 50, 1, 540, []DestinyActivityModeType{}, []SessionPartner{}),
+			},
+		},
+		{
+			name:      "gap measured from the latest end",
+			intervals: []playInterval{interval(0, 60, nil), interval(10, 20, nil), interval(80, 90, nil)},
+			want:      []Session{session(0, 90, 3, 4800, []DestinyActivityModeType{}, []SessionPartner{})},
+		},
+		{
+			name:      "unsorted intervals",
+			intervals: []playInterval{interval(100, 110, nil), interval(0, 10, nil)},
+			want: []Session{
+				session(0, 10, 1, 600, []DestinyActivityModeType{}, []SessionPartner{}),
+				session(100, 110, 1, 600, []DestinyActivityModeType{}, []SessionPartner{}),
+			},
+		},
+		{
+			name: "modes and partners are combined",
+			intervals: []playInterval{
+				interval(0, 10, map[string]float64{"2": 600, "3": 300}, ModeRaid),
+				interval(20, 30, map[string]float64{"3": 600}, ModeRaid, ModeStory),
+			},
+			want: []Session{session(0, 30, 2, 1200, []DestinyActivityModeType{ModeRaid, ModeStory}, []SessionPartner{
+				{MembershipID: "3", Activities: 2, SharedSeconds: 900},
+				{MembershipID: "2", Activities: 1, SharedSeconds: 600},
+			})},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := groupSessions("1", test.intervals, 30)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groupSessions() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Stats batch statuses
+const (
+	BatchRunning  = "running"
+	BatchComplete = "complete"
+	BatchPartial  = "partial"
+)
+
+// Stats batch kinds, named after the collection the batch is stored in
+const (
+	BatchPlayerStats   = "PlayerStats"
+	BatchActivityStats = "ActivityStats"
+)
+
+// StatsBatch records a single RetrievePlayersStats or RetrievePlayersAggregateStats run
+type StatsBatch struct {
+	BatchID   int       `bson:"BatchID"`
+	Kind      string    `bson:"Kind"`
+	Status    string    `bson:"Status"`
+	StartTime time.Time `bson:"StartTime"`
+	EndTime   time.Time `bson:"EndTime,omitempty"`
+	Members   int       `bson:"Members"`
+	Retrieved int       `bson:"Retrieved"`
+	Failed    int       `bson:"Failed"`
+}
+
+type batchCounter struct {
+	ID  string `bson:"_id"`
+	Seq int    `bson:"Seq"`
+}
+
+// InitStatsBatchRegistry migrates a database from before the batch registry existed, by registering the batches already
+// stored and creating the batch ID counter after the highest of them. The counter marks the migration as done, so after
+// the first call this only looks up the counter.
+func InitStatsBatchRegistry() error {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	count, err := db.C("Counters").FindId("StatsBatch").Count()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	err = RegisterLegacyStatsBatches()
+	if err != nil {
+		return err
+	}
+
+	highest := 0
+	var last StatsBatch
+	err = db.C("StatsBatches").Find(nil).Sort("-BatchID").One(&last)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		highest = last.BatchID
+	}
+
+	// Another run may have created the counter in the meantime, in which case that one is used
+	err = db.C("Counters").Insert(batchCounter{ID: "StatsBatch", Seq: highest})
+	if err != nil && !mgo.IsDup(err) {
+		return err
+	}
+
+	return nil
+}
+
+// StartStatsBatch allocates the next batch ID and registers the batch as running. IDs are allocated from a counter that
+// is incremented atomically and shared by all kinds of batches, so concurrent runs never share a batch. The counter
+// starts after the highest batch ID already stored.
+func StartStatsBatch(kind string, members int) (StatsBatch, error) {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	err := InitStatsBatchRegistry()
+	if err != nil {
+		return StatsBatch{}, err
+	}
+
+	var counter batchCounter
+	_, err = db.C("Counters").FindId("StatsBatch").Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"Seq": 1}},
+		ReturnNew: true,
+	}, &counter)
+	if err != nil {
+		return StatsBatch{}, err
+	}
+
+	batch := StatsBatch{
+		BatchID:   counter.Seq,
+		Kind:      kind,
+		Status:    BatchRunning,
+		StartTime: time.Now(),
+		Members:   members,
+	}
+	err = db.C("StatsBatches").Insert(batch)
+
+	return batch, err
+}
+
+// FinishStatsBatch records the end of a batch, which is complete when the stats of every member were retrieved and
+// partial otherwise
+func FinishStatsBatch(batch StatsBatch) error {
+	collectionBatches := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("StatsBatches")
+
+	status := BatchComplete
+	if batch.Failed > 0 || batch.Retrieved < batch.Members {
+		status = BatchPartial
+	}
+
+	return collectionBatches.Update(
+		bson.M{"BatchID": batch.BatchID},
+		bson.M{"$set": bson.M{
+			"Status":    status,
+			"EndTime":   time.Now(),
+			"Retrieved": batch.Retrieved,
+			"Failed":    batch.Failed,
+		}},
+	)
+}
+
+// RegisterLegacyStatsBatches registers the batches stored before the batch registry existed as complete, taking their
+// start and end time from the stats in the batch. It is run once by InitStatsBatchRegistry.
+func RegisterLegacyStatsBatches() error {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	var batchIDs []int
+	err := db.C("PlayerStats").Find(nil).Distinct("BatchID", &batchIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, batchID := range batchIDs {
+		count, err := db.C("StatsBatches").Find(bson.M{"BatchID": batchID}).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		var stats []PlayerStats
+		err = db.C("PlayerStats").Find(bson.M{"BatchID": batchID}).Select(bson.M{"BatchTime": 1}).Sort("BatchTime").All(&stats)
+		if err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			continue
+		}
+
+		fmt.Printf("Registering stats batch %d\r\n", batchID)
+		err = db.C("StatsBatches").Insert(StatsBatch{
+			BatchID:   batchID,
+			Kind:      BatchPlayerStats,
+			Status:    BatchComplete,
+			StartTime: stats[0].BatchTime,
+			EndTime:   stats[len(stats)-1].BatchTime,
+			Members:   len(stats),
+			Retrieved: len(stats),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StatsBatches returns the registered batches of a kind, or of all kinds when kind is empty, oldest first. Unless
+// includeIncomplete is set, only complete batches are returned.
+func StatsBatches(kind string, includeIncomplete bool) ([]StatsBatch, error) {
+	err := InitStatsBatchRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	query := bson.M{}
+	if kind != "" {
+		query["Kind"] = kind
+	}
+	if !includeIncomplete {
+		query["Status"] = BatchComplete
+	}
+
+	collectionBatches := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("StatsBatches")
+	var batches []StatsBatch
+	err = collectionBatches.Find(query).All(&batches)
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].BatchID < batches[j].BatchID
+	})
+
+	return batches, err
+}
+
+// MarkStatsBatchPartial ends a batch that is still registered as running, because its run stopped before finishing it,
+// as partial. The members it retrieved are counted from the stats stored for it.
+func MarkStatsBatchPartial(batchID int) error {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	var batch StatsBatch
+	err := db.C("StatsBatches").Find(bson.M{"BatchID": batchID}).One(&batch)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("unknown stats batch %d", batchID)
+	}
+	if err != nil {
+		return err
+	}
+	if batch.Status != BatchRunning {
+		return fmt.Errorf("stats batch %d is %s, not running", batchID, batch.Status)
+	}
+
+	var memberIDs []string
+	err = db.C(batch.Kind).Find(bson.M{"BatchID": batchID}).Distinct("MembershipID", &memberIDs)
+	if err != nil {
+		return err
+	}
+
+	return db.C("StatsBatches").Update(
+		bson.M{"BatchID": batchID},
+		bson.M{"$set": bson.M{
+			"Status":    BatchPartial,
+			"EndTime":   time.Now(),
+			"Retrieved": len(memberIDs),
+		}},
+	)
+}
+
+// DeleteStatsBatch removes a batch from the registry along with all stats stored for it
+func DeleteStatsBatch(batchID int) error {
+	db := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID))
+
+	for _, kind := range []string{BatchPlayerStats, BatchActivityStats} {
+		info, err := db.C(kind).RemoveAll(bson.M{"BatchID": batchID})
+		if err != nil {
+			return err
+		}
+		if info.Removed > 0 {
+			fmt.Printf("Deleted %d %s documents of batch %d\r\n", info.Removed, kind, batchID)
+		}
+	}
+
+	_, err := db.C("StatsBatches").RemoveAll(bson.M{"BatchID": batchID})
+	return err
+}
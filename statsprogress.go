@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// statMetric is a single value compared between stats batches. Ratios are not summed for the clan totals, but
+// calculated from the totals of their numerator and denominator metrics.
+type statMetric struct {
+	Name        string
+	Value       func(stats PlayerStats) float64
+	Numerator   string
+	Denominator string
+}
+
+// Metrics ranked in the most improved lists
+var improvedMetrics = []string{
+	"PvE.SecondsPlayed", "PvP.SecondsPlayed", "PvE.Kills", "PvP.Kills", "PvE.KD", "PvP.KD",
+	"PvE.PrecisionKills", "PvP.PrecisionKills", "PvE.ResurrectionsPerformed", "PvP.ResurrectionsPerformed",
+}
+
+func ratio(numerator float64, denominator float64) float64 {
+	if denominator == 0 {
+		return numerator
+	}
+
+	return numerator / denominator
+}
+
+// statMetrics returns the compared metrics in report order
+func statMetrics() []statMetric {
+	metrics := []statMetric{
+		{Name: "PvE.SecondsPlayed", Value: func(s PlayerStats) float64 { return s.PvE.SecondsPlayed }},
+		{Name: "PvE.Kills", Value: func(s PlayerStats) float64 { return s.PvE.Kills }},
+		{Name: "PvE.Deaths", Value: func(s PlayerStats) float64 { return s.PvE.Deaths }},
+		{Name: "PvE.KD", Value: func(s PlayerStats) float64 { return ratio(s.PvE.Kills, s.PvE.Deaths) }, Numerator: "PvE.Kills", Denominator: "PvE.Deaths"},
+		{Name: "PvE.PrecisionKills", Value: func(s PlayerStats) float64 { return s.PvE.PrecisionKills }},
+		{Name: "PvE.ResurrectionsPerformed", Value: func(s PlayerStats) float64 { return s.PvE.ResurrectionsPerformed }},
+		{Name: "PvP.SecondsPlayed", Value: func(s PlayerStats) float64 { return s.PvP.SecondsPlayed }},
+		{Name: "PvP.Kills", Value: func(s PlayerStats) float64 { return s.PvP.Kills }},
+		{Name: "PvP.Deaths", Value: func(s PlayerStats) float64 { return s.PvP.Deaths }},
+		{Name: "PvP.KD", Value: func(s PlayerStats) float64 { return ratio(s.PvP.Kills, s.PvP.Deaths) }, Numerator: "PvP.Kills", Denominator: "PvP.Deaths"},
+		{Name: "PvP.PrecisionKills", Value: func(s PlayerStats) float64 { return s.PvP.PrecisionKills }},
+		{Name: "PvP.ResurrectionsPerformed", Value: func(s PlayerStats) float64 { return s.PvP.ResurrectionsPerformed }},
+	}
+	metrics = append(metrics, weaponMetrics("PvE", func(s PlayerStats) WeaponStats { return s.PvE.WeaponKills })...)
+	metrics = append(metrics, weaponMetrics("PvP", func(s PlayerStats) WeaponStats { return s.PvP.WeaponKills })...)
+
+	return metrics
+}
+
+// weaponMetrics returns a metric for every weapon kills category
+func weaponMetrics(prefix string, weapons func(s PlayerStats) WeaponStats) []statMetric {
+	categories := []struct {
+		Name  string
+		Value func(w WeaponStats) float64
+	}{
+		{"AutoRifle", func(w WeaponStats) float64 { return w.AutoRifle }},
+		{"BeamRifle", func(w WeaponStats) float64 { return w.BeamRifle }},
+		{"Bow", func(w WeaponStats) float64 { return w.Bow }},
+		{"FusionRifle", func(w WeaponStats) float64 { return w.FusionRifle }},
+		{"HandCannon", func(w WeaponStats) float64 { return w.HandCannon }},
+		{"TraceRifle", func(w WeaponStats) float64 { return w.TraceRifle }},
+		{"PulseRifle", func(w WeaponStats) float64 { return w.PulseRifle }},
+		{"RocketLauncher", func(w WeaponStats) float64 { return w.RocketLauncher }},
+		{"ScoutRifle", func(w WeaponStats) float64 { return w.ScoutRifle }},
+		{"Shotgun", func(w WeaponStats) float64 { return w.Shotgun }},
+		{"Sniper", func(w WeaponStats) float64 { return w.Sniper }},
+		{"Submachinegun", func(w WeaponStats) float64 { return w.Submachinegun }},
+		{"Relic", func(w WeaponStats) float64 { return w.Relic }},
+		{"SideArm", func(w WeaponStats) float64 { return w.SideArm }},
+		{"Sword", func(w WeaponStats) float64 { return w.Sword }},
+		{"Ability", func(w WeaponStats) float64 { return w.Ability }},
+		{"Grenade", func(w WeaponStats) float64 { return w.Grenade }},
+		{"GrenadeLauncher", func(w WeaponStats) float64 { return w.GrenadeLauncher }},
+		{"Super", func(w WeaponStats) float64 { return w.Super }},
+		{"Melee", func(w WeaponStats) float64 { return w.Melee }},
+	}
+
+	metrics := []statMetric{}
+	for _, category := range categories {
+		value := category.Value
+		metrics = append(metrics, statMetric{
+			Name:  fmt.Sprintf("%s.WeaponKills.%s", prefix, category.Name),
+			Value: func(s PlayerStats) float64 { return value(weapons(s)) },
+		})
+	}
+
+	return metrics
+}
+
+// statCategoryPrefixes returns the metric name prefixes of the given categories. Stats batches only hold PvE and PvP
+// totals, so those are the only categories the stats can be filtered on. No categories means all metrics, for which
+// an empty list is returned.
+func statCategoryPrefixes(categories []string) ([]string, error) {
+	prefixes := []string{}
+	for _, category := range categories {
+		switch {
+		case strings.EqualFold(category, "PvE"):
+			prefixes = append(prefixes, "PvE.")
+		case strings.EqualFold(category, "PvP"):
+			prefixes = append(prefixes, "PvP.")
+		default:
+			return nil, fmt.Errorf("stats can only be filtered on the PvE and PvP categories, not %s", category)
+		}
+	}
+
+	return prefixes, nil
+}
+
+// hasPrefix reports whether a name starts with any of the prefixes, or whether there are no prefixes
+func hasPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatsDelta is the change in every metric of a member between two batches
+type StatsDelta struct {
+	MembershipID string
+	MemberName   string
+	Deltas       map[string]float64
+}
+
+// StatsProgress compares the stats of every member present in both batches and writes the change in each metric, with
+// a clan total, to a TSV file. The members that improved the most on the key metrics are written to a second file.
+// Categories limit the metrics to the PvE or PvP ones.
+func StatsProgress(fromBatch int, toBatch int, postfix string, categories []string) error {
+	prefixes, err := statCategoryPrefixes(categories)
+	if err != nil {
+		return err
+	}
+
+	collectionStats := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("PlayerStats")
+
+	var fromStats, toStats []PlayerStats
+	err = collectionStats.Find(bson.M{"BatchID": fromBatch}).All(&fromStats)
+	if err != nil {
+		return err
+	}
+	err = collectionStats.Find(bson.M{"BatchID": toBatch}).Sort("MemberName").All(&toStats)
+	if err != nil {
+		return err
+	}
+	if len(fromStats) == 0 || len(toStats) == 0 {
+		return fmt.Errorf("no stats found for batch %d or %d", fromBatch, toBatch)
+	}
+
+	previous := map[string]PlayerStats{}
+	for _, stats := range fromStats {
+		previous[stats.MembershipID] = stats
+	}
+
+	metrics := []statMetric{}
+	for _, metric := range statMetrics() {
+		if hasPrefix(metric.Name, prefixes) {
+			metrics = append(metrics, metric)
+		}
+	}
+	deltas := []StatsDelta{}
+	fromTotals := map[string]float64{}
+	toTotals := map[string]float64{}
+	for _, stats := range toStats {
+		before, ok := previous[stats.MembershipID]
+		if !ok {
+			continue
+		}
+
+		delta := StatsDelta{MembershipID: stats.MembershipID, MemberName: stats.MemberName, Deltas: map[string]float64{}}
+		for _, metric := range metrics {
+			delta.Deltas[metric.Name] = metric.Value(stats) - metric.Value(before)
+			fromTotals[metric.Name] += metric.Value(before)
+			toTotals[metric.Name] += metric.Value(stats)
+		}
+		deltas = append(deltas, delta)
+	}
+
+	clan := StatsDelta{MemberName: "Clan total", Deltas: map[string]float64{}}
+	for _, metric := range metrics {
+		if metric.Numerator != "" {
+			clan.Deltas[metric.Name] = ratio(toTotals[metric.Numerator], toTotals[metric.Denominator]) - ratio(fromTotals[metric.Numerator], fromTotals[metric.Denominator])
+		} else {
+			clan.Deltas[metric.Name] = toTotals[metric.Name] - fromTotals[metric.Name]
+		}
+	}
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := []string{"player", "membershipId"}
+	for _, metric := range metrics {
+		header = append(header, metric.Name)
+	}
+	f.WriteString(strings.Join(header, "\t") + "\r\n")
+	for _, delta := range append(deltas, clan) {
+		line := []string{delta.MemberName, delta.MembershipID}
+		for _, metric := range metrics {
+			line = append(line, formatDelta(delta.Deltas[metric.Name]))
+		}
+		f.WriteString(strings.Join(line, "\t") + "\r\n")
+	}
+
+	improved, err := os.Create(fmt.Sprintf("ClanInspector%s_%s_improved.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer improved.Close()
+
+	fmt.Printf("Progress from batch %d to batch %d for %d members\r\n", fromBatch, toBatch, len(deltas))
+	improved.WriteString("metric\trank\tplayer\tchange\r\n")
+	for _, name := range improvedMetrics {
+		if !hasPrefix(name, prefixes) {
+			continue
+		}
+		ranked := append([]StatsDelta{}, deltas...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].Deltas[name] > ranked[j].Deltas[name]
+		})
+
+		fmt.Printf("Most improved %s:", name)
+		for i, delta := range ranked {
+			if i >= 5 || delta.Deltas[name] <= 0 {
+				break
+			}
+			improved.WriteString(fmt.Sprintf("%s\t%d\t%s\t%s\r\n", name, i+1, delta.MemberName, formatDelta(delta.Deltas[name])))
+			fmt.Printf(" %s (%s)", delta.MemberName, formatDelta(delta.Deltas[name]))
+		}
+		fmt.Printf("\r\n")
+	}
+
+	return nil
+}
+
+func formatDelta(value float64) string {
+	return formatWeight(math.Round(value*1000) / 1000)
+}
+
+// ActivityStatsProgress compares the per activity totals of every member present in both batches, summed over their
+// characters, and writes the change for every activity they played in between to a TSV file, with the categories of
+// the activity. With categories given, only activities in those categories are written, which needs the manifest to
+// know the modes of each activity.
+func ActivityStatsProgress(fromBatch int, toBatch int, postfix string, categories []string) error {
+	modes, err := CategoryModes(categories)
+	if err != nil {
+		return err
+	}
+	if len(modes) > 0 && definitions == nil {
+		return fmt.Errorf("the manifest must be loaded to filter activity stats on categories")
+	}
+
+	before, err := MemberActivityTotals(fromBatch)
+	if err != nil {
+		return err
+	}
+	after, err := MemberActivityTotals(toBatch)
+	if err != nil {
+		return err
+	}
+	if len(before) == 0 || len(after) == 0 {
+		return fmt.Errorf("no activity stats found for batch %d or %d", fromBatch, toBatch)
+	}
+
+	members := []string{}
+	for membershipID := range after {
+		if _, ok := before[membershipID]; ok {
+			members = append(members, membershipID)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(after[members[i]].Name) < strings.ToLower(after[members[j]].Name)
+	})
+
+	f, err := os.Create(fmt.Sprintf("ClanInspector%s_%s.tsv", config.ClanID, postfix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f.WriteString("player\tmembershipId\tactivity\tactivityHash\tcategories\tcompletions\tkills\tdeaths\tsecondsPlayed\tfastestBefore\tfastestAfter\r\n")
+	rows := 0
+	for _, membershipID := range members {
+		previous := before[membershipID].Activities
+		current := after[membershipID].Activities
+
+		hashes := []int64{}
+		for hash := range current {
+			hashes = append(hashes, hash)
+		}
+		sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+		for _, hash := range hashes {
+			totals := current[hash]
+			old := previous[hash]
+			if totals.SecondsPlayed == old.SecondsPlayed && totals.Completions == old.Completions {
+				continue
+			}
+			activityModes := ActivityModes(hash)
+			if len(modes) > 0 && !SharesMode(activityModes, modes) {
+				continue
+			}
+
+			f.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\r\n",
+				after[membershipID].Name, membershipID, ActivityName(hash), hash, strings.Join(ModeCategories(activityModes), ", "),
+				formatDelta(totals.Completions-old.Completions), formatDelta(totals.Kills-old.Kills),
+				formatDelta(totals.Deaths-old.Deaths), formatDelta(totals.SecondsPlayed-old.SecondsPlayed),
+				formatDuration(old.FastestCompletionMs), formatDuration(totals.FastestCompletionMs)))
+			rows++
+		}
+	}
+
+	fmt.Printf("Activity progress from batch %d to batch %d: %d activities for %d members\r\n", fromBatch, toBatch, rows, len(members))
+	return nil
+}
+
+// MemberActivities holds the activity totals of a member, summed over their characters
+type MemberActivities struct {
+	Name       string
+	Activities map[int64]ActivityTotals
+}
+
+// MemberActivityTotals returns the activity totals in a batch by membership ID. The fastest completion is the fastest of
+// any of the member's characters.
+func MemberActivityTotals(batchID int) (map[string]*MemberActivities, error) {
+	collectionStats := mongoSession.DB(fmt.Sprintf("ClanInspector%s", config.ClanID)).C("ActivityStats")
+
+	var characters []CharacterActivityStats
+	err := collectionStats.Find(bson.M{"BatchID": batchID}).All(&characters)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]*MemberActivities{}
+	for _, character := range characters {
+		member, ok := members[character.MembershipID]
+		if !ok {
+			member = &MemberActivities{Name: character.MemberName, Activities: map[int64]ActivityTotals{}}
+			members[character.MembershipID] = member
+		}
+
+		for _, activity := range character.Activities {
+			totals := member.Activities[activity.ActivityHash]
+			totals.ActivityHash = activity.ActivityHash
+			totals.Completions += activity.Completions
+			totals.Kills += activity.Kills
+			totals.Deaths += activity.Deaths
+			totals.SecondsPlayed += activity.SecondsPlayed
+			if activity.FastestCompletionMs > 0 && (totals.FastestCompletionMs == 0 || activity.FastestCompletionMs < totals.FastestCompletionMs) {
+				totals.FastestCompletionMs = activity.FastestCompletionMs
+			}
+			member.Activities[activity.ActivityHash] = totals
+		}
+	}
+
+	return members, nil
+}
+
+// formatDuration formats a duration in milliseconds as hours, minutes and seconds, leaving zero empty
+func formatDuration(milliseconds float64) string {
+	if milliseconds <= 0 {
+		return ""
+	}
+
+	return (time.Duration(milliseconds) * time.Millisecond).Round(time.Second).String()
+}
+
+// StatsBatchAt returns the last stats batch of a kind started at or before the given time, skipping incomplete batches unless
+// includeIncomplete is set
+func StatsBatchAt(kind string, date time.Time, includeIncomplete bool) (int, error) {
+	batches, err := StatsBatches(kind, includeIncomplete)
+	if err != nil {
+		return 0, err
+	}
+
+	batchID := 0
+	for _, batch := range batches {
+		if !batch.StartTime.After(date) {
+			batchID = batch.BatchID
+		}
+	}
+	if batchID == 0 {
+		return 0, fmt.Errorf("no stats batch at %s", date.Format("2006-01-02"))
+	}
+
+	return batchID, nil
+}
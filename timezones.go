@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Timezones holds the timezone of every member with a configured timezone, and the clan default for everyone else
+type Timezones struct {
+	Default *time.Location
+	Members map[string]*time.Location
+}
+
+// LoadTimezones loads the clan default timezone and the member timezones. Members are listed by membership ID or
+// display name in MemberTimezones and in the YAML file at MemberTimezonesPath, whose entries take precedence. Without a
+// configured default, UTC is used.
+func LoadTimezones() (Timezones, error) {
+	timezones := Timezones{Default: time.UTC, Members: map[string]*time.Location{}}
+
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return timezones, fmt.Errorf("clan timezone %s: %s", config.Timezone, err.Error())
+		}
+		timezones.Default = location
+	}
+
+	names := map[string]string{}
+	for member, name := range config.MemberTimezones {
+		names[member] = name
+	}
+
+	if config.MemberTimezonesPath != "" {
+		yamlFile, err := ioutil.ReadFile(config.MemberTimezonesPath)
+		if err != nil {
+			return timezones, err
+		}
+
+		overrides := map[string]string{}
+		err = yaml.Unmarshal(yamlFile, &overrides)
+		if err != nil {
+			return timezones, err
+		}
+		for member, name := range overrides {
+			names[member] = name
+		}
+	}
+
+	for member, name := range names {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return timezones, fmt.Errorf("timezone %s of %s: %s", name, member, err.Error())
+		}
+		timezones.Members[member] = location
+	}
+
+	return timezones, nil
+}
+
+// Location returns the timezone of a player, looked up by membership ID first and display name second
+func (timezones Timezones) Location(player Player) *time.Location {
+	if location, ok := timezones.Members[player.MembershipID]; ok {
+		return location
+	}
+	if location, ok := timezones.Members[player.DisplayName]; ok {
+		return location
+	}
+
+	return timezones.Default
+}